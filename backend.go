@@ -2,10 +2,13 @@ package harbor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -22,6 +25,7 @@ var (
 // Factory configures and returns Harbor secrets backends.
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
 	b := backend()
+	b.backendUUID = conf.BackendUUID
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
@@ -33,15 +37,34 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 // target API's client.
 type harborBackend struct {
 	*framework.Backend
-	lock   sync.RWMutex
-	client *harborClient
+	lock sync.RWMutex
+
+	// clients holds a client per configured
+	// connection, keyed by connection name.
+	clients map[string]*harborClient
+
+	// clientsGeneration counts the resets of the clients, so that a client
+	// created from a configuration changed meanwhile isn't cached
+	clientsGeneration uint64
+
+	// staticRoleLock serializes the rotations of the static roles
+	staticRoleLock sync.Mutex
+
+	// tidyLock prevents two tidy operations from running at once
+	tidyLock sync.Mutex
+
+	// backendUUID identifies the mount, it is stamped on
+	// the robot accounts the backend creates
+	backendUUID string
 }
 
 // backend defines the target API backend
 // for Vault. It must include each path
 // and the secrets it will store.
 func backend() *harborBackend {
-	b := harborBackend{}
+	b := harborBackend{
+		clients: make(map[string]*harborClient),
+	}
 
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
@@ -49,14 +72,26 @@ func backend() *harborBackend {
 			LocalStorage: []string{},
 			SealWrapStorage: []string{
 				"config",
+				"config/*",
 				"roles/*",
+				staticRoleStoragePrefix + "*",
 			},
 		},
 		Paths: framework.PathAppend(
 			pathRoles(&b),
+			pathStaticRoles(&b),
+			pathRobots(&b),
 			[]*framework.Path{
+				pathConfigRotateRoot(&b),
+				pathConfigAutoTidy(&b),
 				pathConfig(&b),
+				pathConfigList(&b),
 				pathCreds(&b),
+				pathStaticCreds(&b),
+				pathRotateRole(&b),
+				pathRoleRevokeAll(&b),
+				pathTidy(&b),
+				pathRevocationsPending(&b),
 			},
 		),
 		Secrets: []*framework.Secret{
@@ -64,57 +99,94 @@ func backend() *harborBackend {
 		},
 		BackendType:    logical.TypeLogical,
 		Invalidate:     b.invalidate,
+		PeriodicFunc:   b.periodicFunc,
+		WALRollback:    b.walRollback,
 		RunningVersion: Version,
 	}
 	return &b
 }
 
-// reset clears any client configuration for a new
-// backend to be configured
-func (b *harborBackend) reset() {
+// reset clears the client of a connection for
+// the connection to be configured again
+func (b *harborBackend) reset(connection string) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	b.client = nil
+	delete(b.clients, connection)
+	b.clientsGeneration++
 }
 
 // invalidate clears an existing client configuration in
 // the backend
 func (b *harborBackend) invalidate(ctx context.Context, key string) {
-	if key == "config" {
-		b.reset()
+	switch {
+	case key == configStoragePath:
+		b.reset(defaultConnection)
+	case strings.HasPrefix(key, configStoragePath+"/"):
+		b.reset(strings.TrimPrefix(key, configStoragePath+"/"))
 	}
 }
 
-// getClient locks the backend as it configures and creates a
-// a new client for the target API
-func (b *harborBackend) getClient(ctx context.Context, s logical.Storage) (*harborClient, error) {
-	b.lock.RLock()
-	unlockFunc := b.lock.RUnlock
-
-	//nolint:gocritic
-	defer func() { unlockFunc() }()
-
-	if b.client != nil {
-		return b.client, nil
+// periodicFunc runs the scheduled tasks of the backend, on the nodes
+// which can write to the storage only.
+func (b *harborBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	replicationState := b.System().ReplicationState()
+	if (!b.System().LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+		replicationState.HasState(consts.ReplicationDRSecondary|consts.ReplicationPerformanceStandby) {
+		return nil
 	}
 
+	return errors.Join(
+		b.rotateStaticRoles(ctx, req.Storage),
+		b.enforceRobotExpiry(ctx, req.Storage),
+		b.processRevocations(ctx, req.Storage),
+		b.autoTidy(ctx, req.Storage),
+	)
+}
+
+// getClient returns the client for the target API of a connection, and
+// creates it on first use. The Harbor capabilities are probed without
+// holding the backend lock, and the client is only cached once they are known.
+func (b *harborBackend) getClient(ctx context.Context, s logical.Storage, connection string) (*harborClient, error) {
+	b.lock.RLock()
+	client, ok := b.clients[connection]
+	generation := b.clientsGeneration
 	b.lock.RUnlock()
-	b.lock.Lock()
-	unlockFunc = b.lock.Unlock
 
-	config, err := getConfig(ctx, s)
+	if ok {
+		return client, nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
 
 	if config == nil {
-		config = new(harborConfig)
+		return nil, fmt.Errorf("harbor connection %s is not configured", connectionDisplayName(connection))
 	}
 
-	b.client, err = newClient(config)
+	client, err = newClient(config, b.Logger())
 	if err != nil {
 		return nil, err
 	}
 
-	return b.client, nil
+	client.capabilities, err = probeCapabilities(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// another caller may have created the client while probing
+	if cached, ok := b.clients[connection]; ok {
+		return cached, nil
+	}
+
+	// a configuration changed while probing is picked up by the next call
+	if generation == b.clientsGeneration {
+		b.clients[connection] = client
+	}
+
+	return client, nil
 }
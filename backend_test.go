@@ -2,9 +2,15 @@ package harbor
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -36,6 +42,247 @@ func getTestBackend(tb testing.TB) (*harborBackend, logical.Storage) {
 	return b.(*harborBackend), config.StorageView
 }
 
+// fakeHarbor serves the subset of the Harbor API which the
+// backend calls by itself, so that unit tests can run without Harbor.
+type fakeHarbor struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	Version     string
+	Password    string
+	RobotSecret string
+	Projects    []string
+
+	RobotCreated  time.Time
+	RobotDuration float64
+	RobotDeleted  bool
+
+	// Robots replaces the robot accounts listed, when set
+	Robots         []map[string]interface{}
+	DeletedRobots  []int64
+	DisabledRobots []int64
+
+	// Unavailable fails the calls on the robot accounts of Robots
+	Unavailable bool
+}
+
+// newFakeHarbor starts a fake Harbor API accepting the
+// test username/password and the test robot account.
+func newFakeHarbor(tb testing.TB) *fakeHarbor {
+	tb.Helper()
+
+	f := &fakeHarbor{
+		Version:     "v2.5.0-1a2b3c4d",
+		Password:    password,
+		RobotSecret: robotSecret,
+		Projects:    []string{"library", "public", "team-a-api", "team-a-web", "team-b-api"},
+
+		RobotCreated: time.Now(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2.0/systeminfo", f.handleSystemInfo)
+	mux.HandleFunc("/api/v2.0/users/current", f.handleCurrentUser)
+	mux.HandleFunc("/api/v2.0/users/1/password", f.handleUserPassword)
+	mux.HandleFunc("/api/v2.0/robots", f.handleRobots)
+	mux.HandleFunc("/api/v2.0/robots/7", f.handleRobot)
+	mux.HandleFunc("/api/v2.0/robots/", f.handleListedRobot)
+	mux.HandleFunc("/api/v2.0/projects", f.handleProjects)
+
+	f.Server = httptest.NewServer(mux)
+	tb.Cleanup(f.Close)
+
+	return f
+}
+
+// authenticated returns which kind of account authenticated
+// the request, or an empty string.
+func (f *fakeHarbor) authenticated(r *http.Request) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, p, ok := r.BasicAuth()
+	switch {
+	case ok && u == username && p == f.Password:
+		return authTypeUser
+	case ok && u == robotName && p == f.RobotSecret:
+		return authTypeRobot
+	default:
+		return ""
+	}
+}
+
+func (f *fakeHarbor) handleSystemInfo(w http.ResponseWriter, r *http.Request) {
+	if f.authenticated(r) == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"harbor_version": f.Version, "auth_mode": "db_auth"})
+}
+
+func (f *fakeHarbor) handleCurrentUser(w http.ResponseWriter, r *http.Request) {
+	if f.authenticated(r) != authTypeUser {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"user_id": 1, "username": username, "sysadmin_flag": true})
+}
+
+func (f *fakeHarbor) handleUserPassword(w http.ResponseWriter, r *http.Request) {
+	var passwordReq map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&passwordReq)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != http.MethodPut || passwordReq["old_password"] != f.Password {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.Password = passwordReq["new_password"]
+}
+
+func (f *fakeHarbor) handleRobots(w http.ResponseWriter, r *http.Request) {
+	if f.authenticated(r) == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Robots == nil {
+		robots := []map[string]interface{}{}
+		if !f.RobotDeleted {
+			robots = append(robots, f.robot())
+		}
+		_ = json.NewEncoder(w).Encode(robots)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize == 0 {
+		page, pageSize = 1, len(f.Robots)
+	}
+	nameContains := strings.TrimPrefix(strings.TrimPrefix(r.URL.Query().Get("q"), "name="), "~")
+
+	robots := []map[string]interface{}{}
+	for _, robot := range f.Robots {
+		if strings.Contains(robot["name"].(string), nameContains) {
+			robots = append(robots, robot)
+		}
+	}
+
+	start, end := min((page-1)*pageSize, len(robots)), min(page*pageSize, len(robots))
+	_ = json.NewEncoder(w).Encode(robots[start:end])
+}
+
+// robot returns the test robot account, the lock must be held
+func (f *fakeHarbor) robot() map[string]interface{} {
+	return map[string]interface{}{
+		"id": 7, "name": robotName, "duration": 1, "creation_time": f.RobotCreated.Format(time.RFC3339),
+	}
+}
+
+// handleListedRobot serves the robot accounts of Robots
+func (f *fakeHarbor) handleListedRobot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v2.0/robots/"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Unavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var robot map[string]interface{}
+	for _, listed := range f.Robots {
+		if listed["id"] == id {
+			robot = listed
+		}
+	}
+
+	if robot == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(robot)
+	case http.MethodDelete:
+		f.DeletedRobots = append(f.DeletedRobots, id)
+	case http.MethodPut:
+		var updated map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&updated)
+		if disable, _ := updated["disable"].(bool); disable {
+			f.DisabledRobots = append(f.DisabledRobots, id)
+		}
+		robot["disable"] = updated["disable"]
+		robot["description"] = updated["description"]
+		robot["permissions"] = updated["permissions"]
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeHarbor) handleRobot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(f.robot())
+		return
+	case http.MethodDelete:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.RobotDeleted = true
+		return
+	case http.MethodPut:
+		var robot map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&robot)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.RobotDuration, _ = robot["duration"].(float64)
+		return
+	}
+
+	var robotSec map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&robotSec)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != http.MethodPatch || robotSec["secret"] == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.RobotSecret = robotSec["secret"]
+	_ = json.NewEncoder(w).Encode(robotSec)
+}
+
+func (f *fakeHarbor) handleProjects(w http.ResponseWriter, r *http.Request) {
+	if f.authenticated(r) == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	projects := []map[string]interface{}{}
+	for i, name := range f.Projects {
+		if i >= (page-1)*pageSize && i < page*pageSize {
+			projects = append(projects, map[string]interface{}{"project_id": i + 1, "name": name})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(projects)
+}
+
 // runAcceptanceTests will separate unit tests from
 // acceptance tests, which will make active requests
 // to your target API.
@@ -203,7 +450,7 @@ func (e *testEnv) CleanupRobotAccounts(t *testing.T) {
 		tk := strings.Split(token, "$")[1]
 		b := e.Backend.(*harborBackend)
 
-		client, err := b.getClient(e.Context, e.Storage)
+		client, err := b.getClient(e.Context, e.Storage, defaultConnection)
 		if err != nil {
 			t.Fatal("fatal getting client")
 		}
@@ -0,0 +1,165 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+)
+
+// harborRelease is a Harbor minor release.
+type harborRelease struct {
+	major int
+	minor int
+}
+
+func (r harborRelease) String() string {
+	return fmt.Sprintf("v%d.%d", r.major, r.minor)
+}
+
+// atLeast tells whether the release is the given release or a later one.
+func (r harborRelease) atLeast(other harborRelease) bool {
+	return r.major > other.major || (r.major == other.major && r.minor >= other.minor)
+}
+
+var (
+	// robotAccountsRelease introduced the robot account API the backend
+	// relies on: system and project level robots with a duration in days.
+	robotAccountsRelease = harborRelease{2, 2}
+
+	// coverAllProjectsRelease introduced the "*" namespace
+	// granting project permissions on all projects.
+	coverAllProjectsRelease = harborRelease{2, 5}
+
+	// systemPermissionsRelease introduced the permissions of
+	// kind system for robot accounts.
+	systemPermissionsRelease = harborRelease{2, 10}
+
+	harborVersionRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+)
+
+// harborCapabilities describes which robot account features
+// the Harbor instance of a connection supports. The robot account
+// API is the same from Harbor v2.2, the backend only adapts to the
+// releases lacking the "*" namespace by expanding it, see
+// expandNamespaces, and refuses the features a release lacks.
+type harborCapabilities struct {
+	// version is the version reported by Harbor
+	version string
+	// release is nil when the version could not be
+	// parsed, every feature is then assumed to be supported
+	release *harborRelease
+}
+
+// newHarborCapabilities returns the capabilities of a Harbor version.
+func newHarborCapabilities(version string) *harborCapabilities {
+	caps := &harborCapabilities{version: version}
+
+	matches := harborVersionRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return caps
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	caps.release = &harborRelease{major, minor}
+
+	return caps
+}
+
+// probeCapabilities asks Harbor for its version
+// and returns the matching capabilities.
+func probeCapabilities(ctx context.Context, c *harborClient) (*harborCapabilities, error) {
+	info, err := c.getSystemInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error detecting the Harbor version: %w", err)
+	}
+
+	caps := newHarborCapabilities(info.HarborVersion)
+	if caps.release == nil {
+		c.logger.Warn("unable to parse the Harbor version, assuming the latest Harbor features", "version", info.HarborVersion)
+	}
+
+	return caps, nil
+}
+
+// supports tells whether the Harbor release is the given release or a later one.
+func (c *harborCapabilities) supports(release harborRelease) bool {
+	return c.release == nil || c.release.atLeast(release)
+}
+
+// unsupported returns the error for a feature the Harbor release lacks.
+func (c *harborCapabilities) unsupported(feature string, release harborRelease) error {
+	return fmt.Errorf("%s requires Harbor %s or later, but the connection runs Harbor %s", feature, release, c.version)
+}
+
+// checkRobotAccounts verifies that robot accounts can be managed.
+func (c *harborCapabilities) checkRobotAccounts() error {
+	if !c.supports(robotAccountsRelease) {
+		return c.unsupported("managing robot accounts", robotAccountsRelease)
+	}
+
+	return nil
+}
+
+// checkPermissionKinds verifies that robot accounts can be granted
+// permissions of the kinds of the given permissions. The roles are
+// checked with it, as their namespaces are only expanded on issue.
+func (c *harborCapabilities) checkPermissionKinds(permissions []*harborModel.RobotPermission) error {
+	if err := c.checkRobotAccounts(); err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		if permission.Kind == permissionKindSystem && !c.supports(systemPermissionsRelease) {
+			return c.unsupported("a permission of kind system", systemPermissionsRelease)
+		}
+	}
+
+	return nil
+}
+
+// checkPermissions verifies that robot accounts can be
+// granted the given permissions.
+func (c *harborCapabilities) checkPermissions(permissions []*harborModel.RobotPermission) error {
+	if err := c.checkPermissionKinds(permissions); err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		if permission.Kind == permissionKindProject && permission.Namespace == allProjectsNamespace &&
+			!c.supports(coverAllProjectsRelease) {
+			return c.unsupported(fmt.Sprintf("a permission on all projects (namespace %q)", allProjectsNamespace), coverAllProjectsRelease)
+		}
+	}
+
+	return nil
+}
+
+// knownCapabilities returns the capabilities of the Harbor instance of a
+// connection without calling Harbor: those probed by its client, or those
+// of the version recorded when the connection was verified. It returns nil
+// when the version isn't known yet.
+func (b *harborBackend) knownCapabilities(ctx context.Context, s logical.Storage, connection string) (*harborCapabilities, error) {
+	b.lock.RLock()
+	client, ok := b.clients[connection]
+	b.lock.RUnlock()
+
+	if ok {
+		return client.capabilities, nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil || config.HarborVersion == "" {
+		return nil, nil
+	}
+
+	return newHarborCapabilities(config.HarborVersion), nil
+}
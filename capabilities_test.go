@@ -0,0 +1,79 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCapabilities checks the robot account features
+// detected from the Harbor version.
+func TestCapabilities(t *testing.T) {
+	allProjects := []*harborModel.RobotPermission{{Kind: permissionKindProject, Namespace: allProjectsNamespace}}
+	system := []*harborModel.RobotPermission{{Kind: permissionKindSystem, Namespace: "/"}}
+	project := []*harborModel.RobotPermission{{Kind: permissionKindProject, Namespace: "public"}}
+
+	tests := []struct {
+		version          string
+		robotAccounts    bool
+		allProjects      bool
+		systemPermission bool
+	}{
+		{"v2.1.3-8e4e5b6f", false, false, false},
+		{"v2.2.0-1a2b3c4d", true, false, false},
+		{"v2.5.0-1a2b3c4d", true, true, false},
+		{"v2.9.1", true, true, false},
+		{"v2.10.0-b6de84c5", true, true, true},
+		{"v3.0.0", true, true, true},
+		{"dev", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			caps := newHarborCapabilities(tt.version)
+
+			require.Equal(t, tt.robotAccounts, caps.checkRobotAccounts() == nil)
+			require.Equal(t, tt.robotAccounts, caps.checkPermissions(project) == nil)
+			require.Equal(t, tt.allProjects, caps.checkPermissions(allProjects) == nil)
+			require.Equal(t, tt.systemPermission, caps.checkPermissions(system) == nil)
+		})
+	}
+
+	err := newHarborCapabilities("v2.2.0").checkPermissions(system)
+	require.ErrorContains(t, err, "requires Harbor v2.10 or later")
+}
+
+// TestRoleCapabilities uses a fake Harbor API to check that a role asking
+// for something its Harbor version can't do is rejected when written.
+func TestRoleCapabilities(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + roleName,
+		Storage:   s,
+		Data: map[string]interface{}{
+			"permissions": `[{"kind":"system","namespace":"/","access":[{"resource":"project","action":"create"}]}]`,
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "requires Harbor v2.10 or later")
+
+	_, err = testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions": testPermissions,
+	})
+	require.NoError(t, err)
+}
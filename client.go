@@ -1,10 +1,24 @@
 package harbor
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
 
+	runtimeclient "github.com/go-openapi/runtime/client"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-hclog"
 	harbor "github.com/mittwald/goharbor-client/v5/apiv2"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
 	harborCfg "github.com/mittwald/goharbor-client/v5/apiv2/pkg/config"
 )
 
@@ -12,20 +26,66 @@ import (
 // the client.
 type harborClient struct {
 	*harbor.RESTClient
+
+	httpClient *http.Client
+	apiURL     string
+	username   string
+	password   string
+
+	// capabilities is probed when the client is created
+	capabilities *harborCapabilities
+
+	logger          hclog.Logger
+	requestTimeout  time.Duration
+	maxRetries      int
+	retryMinBackoff time.Duration
+	retryMaxBackoff time.Duration
+}
+
+// harborAPIError is returned by the calls the client
+// sends by itself when Harbor answers with an error status.
+type harborAPIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *harborAPIError) Error() string {
+	return fmt.Sprintf("harbor API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// isHarborNotFound tells whether Harbor answered that the resource doesn't exist.
+func isHarborNotFound(err error) bool {
+	var apiErr *harborAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// harborUser is the subset of the Harbor user model the backend uses.
+type harborUser struct {
+	UserID       int64  `json:"user_id"`
+	Username     string `json:"username"`
+	SysadminFlag bool   `json:"sysadmin_flag"`
 }
 
 // newClient creates a new client to access harbor
 // and exposes it for any secrets or roles to use.
-func newClient(config *harborConfig) (*harborClient, error) {
+func newClient(config *harborConfig, logger hclog.Logger) (*harborClient, error) {
 	if config == nil {
 		return nil, errors.New("client configuration was nil")
 	}
 
-	if config.Username == "" {
+	name, secret := config.credentials()
+
+	if name == "" {
+		if config.AuthType == authTypeRobot {
+			return nil, errors.New("client robot name was not defined")
+		}
 		return nil, errors.New("client username was not defined")
 	}
 
-	if config.Password == "" {
+	if secret == "" {
+		if config.AuthType == authTypeRobot {
+			return nil, errors.New("client robot secret was not defined")
+		}
 		return nil, errors.New("client password was not defined")
 	}
 
@@ -33,15 +93,333 @@ func newClient(config *harborConfig) (*harborClient, error) {
 		return nil, errors.New("client URL was not defined")
 	}
 
+	apiURL, err := neturl.Parse(fmt.Sprintf("%s/api/v2.0", strings.TrimSuffix(config.URL, "/")))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing client URL: %w", err)
+	}
+
+	httpClient, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
 	c, err := harbor.NewRESTClientForHost(
-		fmt.Sprintf("%s/api/v2.0", config.URL),
-		config.Username,
-		config.Password,
+		apiURL.String(),
+		name,
+		secret,
 		&harborCfg.Options{PageSize: 100},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &harborClient{c}, nil
+	// Route every Harbor API call through our own HTTP client,
+	// so that the TLS settings of the configuration are honored.
+	c.V2Client.SetTransport(runtimeclient.NewWithClient(apiURL.Host, apiURL.Path, []string{apiURL.Scheme}, httpClient))
+
+	return &harborClient{
+		RESTClient:      c,
+		httpClient:      httpClient,
+		apiURL:          apiURL.String(),
+		username:        name,
+		password:        secret,
+		logger:          logger,
+		requestTimeout:  config.RequestTimeout,
+		maxRetries:      config.MaxRetries,
+		retryMinBackoff: config.RetryMinBackoff,
+		retryMaxBackoff: config.RetryMaxBackoff,
+	}, nil
+}
+
+// do sends a request to a Harbor API endpoint which
+// is not covered by the goharbor client. The request body
+// and the response are encoded as JSON.
+func (c *harborClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	return c.call(ctx, method+" "+path, func(ctx context.Context) error {
+		return c.send(ctx, method, path, body, out)
+	})
+}
+
+// send sends a single request to the Harbor API for do.
+func (c *harborClient) send(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &harborAPIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("error decoding Harbor response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getCurrentUser returns the Harbor user the client is authenticated as.
+func (c *harborClient) getCurrentUser(ctx context.Context) (*harborUser, error) {
+	user := new(harborUser)
+	if err := c.do(ctx, http.MethodGet, "/users/current", nil, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// harborSystemInfo is the subset of the Harbor system information the backend uses.
+type harborSystemInfo struct {
+	HarborVersion string `json:"harbor_version"`
+	AuthMode      string `json:"auth_mode"`
+}
+
+// getSystemInfo returns the Harbor system information. The Harbor
+// version is only reported to authenticated clients.
+func (c *harborClient) getSystemInfo(ctx context.Context) (*harborSystemInfo, error) {
+	info := new(harborSystemInfo)
+	if err := c.do(ctx, http.MethodGet, "/systeminfo", nil, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// harborRobot is the subset of the Harbor robot account model the backend uses.
+type harborRobot struct {
+	ID          int64                          `json:"id"`
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Level       string                         `json:"level"`
+	Disable     bool                           `json:"disable"`
+	Duration    int64                          `json:"duration"`
+	ExpiresAt   int64                          `json:"expires_at"`
+	Editable    bool                           `json:"editable"`
+	Permissions []*harborModel.RobotPermission `json:"permissions"`
+
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// getRobot returns a robot account by its ID.
+func (c *harborClient) getRobot(ctx context.Context, robotID int64) (*harborRobot, error) {
+	robot := new(harborRobot)
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/robots/%d", robotID), nil, robot); err != nil {
+		return nil, err
+	}
+
+	return robot, nil
+}
+
+// getRobotByName looks a robot account up by its name, with or
+// without the Harbor robot prefix. It returns nil if no robot
+// account has this name.
+func (c *harborClient) getRobotByName(ctx context.Context, name string) (*harborRobot, error) {
+	if i := strings.Index(name, "$"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	query := neturl.Values{}
+	query.Set("q", "name="+name)
+
+	var robots []*harborRobot
+	if err := c.do(ctx, http.MethodGet, "/robots?"+query.Encode(), nil, &robots); err != nil {
+		return nil, err
+	}
+
+	for _, robot := range robots {
+		if robot.Name == name || strings.HasSuffix(robot.Name, "$"+name) {
+			return robot, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// listRobotsPageSize is the page size used to list the Harbor robot accounts
+const listRobotsPageSize = 100
+
+// listRobots returns the Harbor robot accounts whose name contains the given string.
+func (c *harborClient) listRobots(ctx context.Context, nameContains string) ([]*harborRobot, error) {
+	var robots []*harborRobot
+
+	for page := 1; ; page++ {
+		query := neturl.Values{}
+		query.Set("page", fmt.Sprint(page))
+		query.Set("page_size", fmt.Sprint(listRobotsPageSize))
+		if nameContains != "" {
+			query.Set("q", "name=~"+nameContains)
+		}
+
+		var pageRobots []*harborRobot
+		if err := c.do(ctx, http.MethodGet, "/robots?"+query.Encode(), nil, &pageRobots); err != nil {
+			return nil, err
+		}
+
+		robots = append(robots, pageRobots...)
+
+		if len(pageRobots) < listRobotsPageSize {
+			return robots, nil
+		}
+	}
+}
+
+// refreshRobotSecret sets a new secret on a robot account
+// and returns the secret now in effect.
+func (c *harborClient) refreshRobotSecret(ctx context.Context, robotID int64, secret string) (string, error) {
+	robotSec := map[string]string{"secret": secret}
+
+	var refreshed struct {
+		Secret string `json:"secret"`
+	}
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/robots/%d", robotID), robotSec, &refreshed); err != nil {
+		return "", err
+	}
+
+	if refreshed.Secret != "" {
+		return refreshed.Secret, nil
+	}
+
+	return secret, nil
+}
+
+// updateRobot updates a robot account, Harbor recomputes
+// its expiry from its creation time and its duration.
+func (c *harborClient) updateRobot(ctx context.Context, robot *harborRobot) error {
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/robots/%d", robot.ID), robot, nil)
+}
+
+// deleteRobot deletes a robot account by its ID.
+func (c *harborClient) deleteRobot(ctx context.Context, robotID int64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/robots/%d", robotID), nil, nil)
+}
+
+// harborProject is the subset of the Harbor project model the backend uses.
+type harborProject struct {
+	ProjectID int64  `json:"project_id"`
+	Name      string `json:"name"`
+}
+
+// listProjectsPageSize is the page size used to list the Harbor projects
+const listProjectsPageSize = 100
+
+// listProjects returns every Harbor project the client can see.
+func (c *harborClient) listProjects(ctx context.Context) ([]*harborProject, error) {
+	var projects []*harborProject
+
+	for page := 1; ; page++ {
+		query := neturl.Values{}
+		query.Set("page", fmt.Sprint(page))
+		query.Set("page_size", fmt.Sprint(listProjectsPageSize))
+
+		var pageProjects []*harborProject
+		if err := c.do(ctx, http.MethodGet, "/projects?"+query.Encode(), nil, &pageProjects); err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, pageProjects...)
+
+		if len(pageProjects) < listProjectsPageSize {
+			return projects, nil
+		}
+	}
+}
+
+// updateUserPassword changes the password of a Harbor user.
+func (c *harborClient) updateUserPassword(ctx context.Context, userID int64, oldPassword, newPassword string) error {
+	passwordReq := map[string]string{
+		"old_password": oldPassword,
+		"new_password": newPassword,
+	}
+
+	body, err := json.Marshal(passwordReq)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/users/%d/password", userID)
+
+	// once Harbor changed the password, a repeated call would send
+	// a stale old password and fail
+	return c.callNonIdempotent(ctx, http.MethodPut+" "+path, func(ctx context.Context) error {
+		return c.send(ctx, http.MethodPut, path, body, nil)
+	})
+}
+
+// newHTTPClient creates the HTTP client used for all
+// calls to the Harbor API.
+func newHTTPClient(config *harborConfig) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: &statusRecordingTransport{next: transport}}, nil
+}
+
+// newTLSConfig builds the TLS configuration for the Harbor
+// connection from the CA bundle, client certificate and
+// server name settings of the configuration.
+func newTLSConfig(config *harborConfig) (*tls.Config, error) {
+	//nolint:gosec // InsecureSkipVerify is an explicit opt-in of the operator
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CACert != "" {
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM([]byte(config.CACert)); !ok {
+			return nil, errors.New("could not parse any certificate from ca_cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, errors.New("client_cert and client_key must be set together")
+	}
+
+	if config.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(config.ClientCert), []byte(config.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
 }
@@ -0,0 +1,128 @@
+package harbor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// responseStatus records the HTTP status of the
+// last response Harbor sent during an API call.
+type responseStatus struct {
+	code int
+}
+
+type responseStatusKey struct{}
+
+// statusRecordingTransport records the status of the Harbor responses
+// into the responseStatus carried by the request context, so that failed
+// calls can be classified whatever error the goharbor client turned them into.
+type statusRecordingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *statusRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if status, ok := req.Context().Value(responseStatusKey{}).(*responseStatus); ok {
+		status.code = 0
+		if resp != nil {
+			status.code = resp.StatusCode
+		}
+	}
+
+	return resp, err
+}
+
+// call runs a Harbor API call with the configured request timeout.
+// Calls failing on server errors, rate limiting or connection errors
+// are retried with a jittered exponential backoff, while the other
+// errors, like validation errors, are returned right away.
+func (c *harborClient) call(ctx context.Context, operation string, fn func(context.Context) error) error {
+	return c.retry(ctx, operation, isRetryable, fn)
+}
+
+// callNonIdempotent runs a Harbor API call which can't be repeated, like the
+// creation of a robot account: a call failing on a server or connection error
+// may have been carried out by Harbor, so it is only retried when rate limited.
+func (c *harborClient) callNonIdempotent(ctx context.Context, operation string, fn func(context.Context) error) error {
+	return c.retry(ctx, operation, isRateLimited, fn)
+}
+
+// retry runs a Harbor API call with the configured request timeout, and
+// retries it with a jittered exponential backoff while it fails on errors
+// the retryable function accepts.
+func (c *harborClient) retry(
+	ctx context.Context,
+	operation string,
+	retryable func(err error, statusCode int) bool,
+	fn func(context.Context) error,
+) error {
+	backoff := c.retryMinBackoff
+
+	for attempt := 1; ; attempt++ {
+		status := new(responseStatus)
+		attemptCtx, cancel := context.WithTimeout(context.WithValue(ctx, responseStatusKey{}, status), c.requestTimeout)
+		err := fn(attemptCtx)
+		cancel()
+
+		if err == nil {
+			c.logger.Debug("harbor API call succeeded", "operation", operation, "attempt", attempt)
+			return nil
+		}
+
+		if ctx.Err() != nil || !retryable(err, status.code) || attempt > c.maxRetries {
+			c.logger.Warn("harbor API call failed", "operation", operation, "attempt", attempt, "status", status.code, "error", err)
+			return err
+		}
+
+		wait := jitter(backoff)
+		c.logger.Warn("harbor API call failed, retrying", "operation", operation, "attempt", attempt,
+			"status", status.code, "backoff", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > c.retryMaxBackoff {
+			backoff = c.retryMaxBackoff
+		}
+	}
+}
+
+// isRetryable tells whether a failed call is worth retrying,
+// based on the status of the last Harbor response.
+func isRetryable(err error, statusCode int) bool {
+	switch {
+	case statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError:
+		return true
+	case statusCode >= http.StatusBadRequest:
+		return false
+	}
+
+	// no error status was received: retry when
+	// Harbor could not be reached or did not answer in time
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isRateLimited tells whether a failed call was rejected by the Harbor rate limiting.
+func isRateLimited(_ error, statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests
+}
+
+// jitter returns a random duration between half and all of the backoff.
+func jitter(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+
+	//nolint:gosec // the jitter does not need a cryptographic random source
+	return time.Duration(half + rand.Int63n(half+1))
+}
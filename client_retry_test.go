@@ -0,0 +1,103 @@
+package harbor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClientRetry checks which failed Harbor API calls are retried.
+func TestClientRetry(t *testing.T) {
+	var attempts int
+	var statuses []int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[attempts]
+		attempts++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client, err := newClient(&harborConfig{
+		AuthType:        authTypeUser,
+		Username:        username,
+		Password:        password,
+		URL:             server.URL,
+		RequestTimeout:  time.Second,
+		MaxRetries:      2,
+		RetryMinBackoff: time.Millisecond,
+		RetryMaxBackoff: time.Millisecond,
+	}, hclog.NewNullLogger())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		statuses         []int
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{"success", []int{http.StatusOK}, 1, false},
+		{"server errors", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 3, false},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"retries exhausted", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, 3, true},
+		{"validation error", []int{http.StatusBadRequest, http.StatusOK}, 1, true},
+		{"conflict", []int{http.StatusConflict, http.StatusOK}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts = 0
+			statuses = tt.statuses
+
+			err := client.do(context.Background(), http.MethodGet, "/systeminfo", nil, nil)
+			require.Equal(t, tt.expectedErr, err != nil)
+			require.Equal(t, tt.expectedAttempts, attempts)
+		})
+	}
+
+	nonIdempotentTests := []struct {
+		name             string
+		statuses         []int
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{"non idempotent server error", []int{http.StatusBadGateway, http.StatusOK}, 1, true},
+		{"non idempotent rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+	}
+
+	for _, tt := range nonIdempotentTests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts = 0
+			statuses = tt.statuses
+
+			err := client.callNonIdempotent(context.Background(), "create", func(ctx context.Context) error {
+				return client.send(ctx, http.MethodPost, "/robots", nil, nil)
+			})
+			require.Equal(t, tt.expectedErr, err != nil)
+			require.Equal(t, tt.expectedAttempts, attempts)
+		})
+	}
+
+	t.Run("password change server error", func(t *testing.T) {
+		attempts = 0
+		statuses = []int{http.StatusBadGateway, http.StatusOK}
+
+		err := client.updateUserPassword(context.Background(), 1, password, "new-password")
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, isRetryable(errors.New("bad gateway"), http.StatusBadGateway))
+	require.True(t, isRetryable(errors.New("slow down"), http.StatusTooManyRequests))
+	require.False(t, isRetryable(errors.New("not found"), http.StatusNotFound))
+	require.True(t, isRetryable(context.DeadlineExceeded, 0))
+	require.False(t, isRetryable(errors.New("invalid permissions"), 0))
+}
@@ -0,0 +1,147 @@
+package harbor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"regexp"
+)
+
+const (
+	outputFormatDefault          = "default"
+	outputFormatDockerConfigJSON = "dockerconfigjson"
+
+	kubernetesSecretType = "kubernetes.io/dockerconfigjson"
+)
+
+var (
+	// kubernetesNameRegex matches the names of the Kubernetes Secrets, DNS subdomains
+	kubernetesNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+	// kubernetesNamespaceRegex matches the Kubernetes namespaces, DNS labels
+	kubernetesNamespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// dockerConfigOutput is how the credentials are output in the
+// dockerconfigjson output format.
+type dockerConfigOutput struct {
+	RegistryHost        string
+	KubernetesName      string
+	KubernetesNamespace string
+}
+
+// dockerConfig is the document docker login writes, as
+// the .dockerconfigjson key of the Kubernetes Secrets.
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// registryHost returns the host of the Harbor registry, with its port, from the URL of a connection.
+func registryHost(url string) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in URL %q", url)
+	}
+
+	return u.Host, nil
+}
+
+// validateRegistryHost checks a registry host is a host, with an optional port.
+func validateRegistryHost(host string) error {
+	u, err := neturl.Parse("//" + host)
+	if err != nil || u.Host != host || u.User != nil || host == "" {
+		return fmt.Errorf("invalid registry host %q, must be a host with an optional port", host)
+	}
+
+	return nil
+}
+
+// validateKubernetesSecret checks the name and the namespace of a Kubernetes Secret.
+func validateKubernetesSecret(name, namespace string) error {
+	if len(name) > 253 || !kubernetesNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid kubernetes_secret_name %q, must be a DNS subdomain", name)
+	}
+
+	if namespace != "" && (len(namespace) > 63 || !kubernetesNamespaceRegex.MatchString(namespace)) {
+		return fmt.Errorf("invalid kubernetes_namespace %q, must be a DNS label", namespace)
+	}
+
+	return nil
+}
+
+// dockerConfigJSON returns the docker config JSON document
+// authenticating a robot account on a registry.
+func dockerConfigJSON(host string, robotAccount *harborRobotAccount) (string, error) {
+	config, err := json.Marshal(&dockerConfig{
+		Auths: map[string]dockerConfigAuth{
+			host: {
+				Username: robotAccount.Name,
+				Password: robotAccount.Secret,
+				Auth:     robotAccount.AuthToken,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(config), nil
+}
+
+// kubernetesSecretManifest returns the manifest of a Kubernetes
+// Secret holding a docker config JSON document.
+func kubernetesSecretManifest(name, namespace, dockerConfigJSON string) (string, error) {
+	metadata := map[string]string{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata,
+		"type":       kubernetesSecretType,
+		"data": map[string]string{
+			".dockerconfigjson": base64.StdEncoding.EncodeToString([]byte(dockerConfigJSON)),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(manifest), nil
+}
+
+// toResponseData returns the response data of a robot account in the dockerconfigjson output format
+func (o *dockerConfigOutput) toResponseData(robotAccount *harborRobotAccount) (map[string]interface{}, error) {
+	config, err := dockerConfigJSON(o.RegistryHost, robotAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"registry_host":    o.RegistryHost,
+		"dockerconfigjson": config,
+	}
+
+	if o.KubernetesName != "" {
+		manifest, err := kubernetesSecretManifest(o.KubernetesName, o.KubernetesNamespace, config)
+		if err != nil {
+			return nil, err
+		}
+		data["kubernetes_secret"] = manifest
+	}
+
+	return data, nil
+}
@@ -0,0 +1,133 @@
+package harbor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryHost(t *testing.T) {
+	host, err := registryHost("https://harbor.internal.domain:8443/")
+	require.NoError(t, err)
+	require.Equal(t, "harbor.internal.domain:8443", host)
+
+	_, err = registryHost("harbor.internal.domain")
+	require.Error(t, err)
+
+	require.NoError(t, validateRegistryHost("registry.internal.domain"))
+	require.NoError(t, validateRegistryHost("registry.internal.domain:5000"))
+	require.Error(t, validateRegistryHost("https://registry.internal.domain"))
+	require.Error(t, validateRegistryHost("registry.internal.domain/library"))
+	require.Error(t, validateRegistryHost(""))
+}
+
+func TestDockerConfigOutput(t *testing.T) {
+	robotAccount := &harborRobotAccount{
+		Name:      robotName,
+		Secret:    robotSecret,
+		AuthToken: base64.StdEncoding.EncodeToString([]byte(robotName + ":" + robotSecret)),
+	}
+
+	t.Run("docker config", func(t *testing.T) {
+		output := &dockerConfigOutput{RegistryHost: "harbor.internal.domain"}
+
+		data, err := output.toResponseData(robotAccount)
+		require.NoError(t, err)
+		require.NotContains(t, data, "kubernetes_secret")
+
+		var config dockerConfig
+		require.NoError(t, json.Unmarshal([]byte(data["dockerconfigjson"].(string)), &config))
+		require.Equal(t, dockerConfigAuth{
+			Username: robotName,
+			Password: robotSecret,
+			Auth:     robotAccount.AuthToken,
+		}, config.Auths["harbor.internal.domain"])
+	})
+
+	t.Run("kubernetes secret", func(t *testing.T) {
+		output := &dockerConfigOutput{
+			RegistryHost:        "harbor.internal.domain",
+			KubernetesName:      "harbor-pull",
+			KubernetesNamespace: "ci",
+		}
+
+		data, err := output.toResponseData(robotAccount)
+		require.NoError(t, err)
+
+		var manifest struct {
+			Kind     string            `json:"kind"`
+			Type     string            `json:"type"`
+			Metadata map[string]string `json:"metadata"`
+			Data     map[string]string `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data["kubernetes_secret"].(string)), &manifest))
+		require.Equal(t, "Secret", manifest.Kind)
+		require.Equal(t, "kubernetes.io/dockerconfigjson", manifest.Type)
+		require.Equal(t, map[string]string{"name": "harbor-pull", "namespace": "ci"}, manifest.Metadata)
+
+		config, err := base64.StdEncoding.DecodeString(manifest.Data[".dockerconfigjson"])
+		require.NoError(t, err)
+		require.Equal(t, data["dockerconfigjson"], string(config))
+	})
+}
+
+// TestCredsOutputValidation checks the output options of a credentials
+// request are rejected before the robot account is created.
+func TestCredsOutputValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	_, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions": testPermissions,
+	})
+	require.NoError(t, err)
+
+	t.Run("invalid role registry host", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, "docker", map[string]interface{}{
+			"permissions":   testPermissions,
+			"output_format": "dockerconfigjson",
+			"registry_host": "https://harbor.internal.domain",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		err  string
+	}{
+		{
+			"kubernetes secret without docker config",
+			map[string]interface{}{"kubernetes_secret_name": "harbor-pull"},
+			"only used when output_format is dockerconfigjson",
+		},
+		{
+			"invalid registry host",
+			map[string]interface{}{"output_format": "dockerconfigjson", "registry_host": "harbor.internal.domain/library"},
+			"invalid registry host",
+		},
+		{
+			"invalid kubernetes secret name",
+			map[string]interface{}{"output_format": "dockerconfigjson", "kubernetes_secret_name": "Harbor_Pull"},
+			"invalid kubernetes_secret_name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "creds/" + roleName,
+				Storage:   s,
+				Data:      tt.data,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/logical"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+)
+
+// regexNamespacePrefix marks a namespace as a regular expression
+// matching the names of the projects, e.g. `regex:^team-a-.+$`.
+const regexNamespacePrefix = "regex:"
+
+// projectNameRegex matches the project names Harbor accepts
+var projectNameRegex = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// isNamespaceTemplate tells whether the namespace of a project permission
+// holds Vault identity templates, such as {{identity.entity.metadata.team}}.
+func isNamespaceTemplate(namespace string) bool {
+	return strings.Contains(namespace, "{{")
+}
+
+// checkNamespaceTemplate checks the syntax of the identity templates of a namespace.
+func checkNamespaceTemplate(namespace string) error {
+	_, _, err := identitytpl.PopulateString(identitytpl.PopulateStringInput{
+		String:            namespace,
+		ValidityCheckOnly: true,
+		Mode:              identitytpl.ACLTemplating,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid namespace template %q: %w", namespace, err)
+	}
+
+	return nil
+}
+
+// resolveNamespaceTemplates returns a copy of the permissions with the identity
+// templates of their namespaces resolved for the entity and its groups.
+// A templated namespace must resolve to a project name: it can't widen the
+// permission to a pattern, or to all projects, through the entity metadata.
+func resolveNamespaceTemplates(
+	permissions []*harborModel.RobotPermission,
+	entity *logical.Entity,
+	groups []*logical.Group,
+) ([]*harborModel.RobotPermission, error) {
+	resolved := make([]*harborModel.RobotPermission, 0, len(permissions))
+
+	for _, permission := range permissions {
+		if permission.Kind != permissionKindProject || !isNamespaceTemplate(permission.Namespace) {
+			resolved = append(resolved, permission)
+			continue
+		}
+
+		_, namespace, err := identitytpl.PopulateString(identitytpl.PopulateStringInput{
+			String: permission.Namespace,
+			Entity: entity,
+			Groups: groups,
+			Mode:   identitytpl.ACLTemplating,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve namespace template %q: %w", permission.Namespace, err)
+		}
+
+		if !projectNameRegex.MatchString(namespace) {
+			return nil, fmt.Errorf("namespace template %q resolved to %q, which is not a valid project name",
+				permission.Namespace, namespace)
+		}
+
+		resolved = append(resolved, &harborModel.RobotPermission{
+			Kind:      permission.Kind,
+			Namespace: namespace,
+			Access:    permission.Access,
+		})
+	}
+
+	return resolved, nil
+}
+
+// isNamespacePattern tells whether the namespace of a project permission
+// is a glob or regex pattern, to be expanded against the Harbor projects.
+// The "*" namespace is left to Harbor, which grants all projects.
+func isNamespacePattern(namespace string) bool {
+	if namespace == allProjectsNamespace {
+		return false
+	}
+
+	return strings.HasPrefix(namespace, regexNamespacePrefix) || strings.ContainsAny(namespace, "*?[")
+}
+
+// compileNamespacePattern returns the matcher of a namespace pattern.
+// Regular expressions must match the whole project name.
+func compileNamespacePattern(namespace string) (func(project string) bool, error) {
+	if expr, ok := strings.CutPrefix(namespace, regexNamespacePrefix); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regex %q: %w", expr, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(namespace, ""); err != nil {
+		return nil, fmt.Errorf("invalid namespace glob %q: %w", namespace, err)
+	}
+
+	return func(project string) bool {
+		matched, _ := path.Match(namespace, project)
+		return matched
+	}, nil
+}
+
+// expandNamespaces resolves the namespace patterns of the project
+// permissions into permissions on the matching Harbor projects.
+// The "*" namespace is kept as is when coverAllProjects is set and
+// Harbor supports it, otherwise it is expanded to every project.
+func expandNamespaces(
+	ctx context.Context,
+	c *harborClient,
+	permissions []*harborModel.RobotPermission,
+	coverAllProjects bool,
+) ([]*harborModel.RobotPermission, error) {
+	coverAll := coverAllProjects && c.capabilities.supports(coverAllProjectsRelease)
+
+	var (
+		projects []*harborProject
+		listed   bool
+	)
+	expanded := make([]*harborModel.RobotPermission, 0, len(permissions))
+	byNamespace := make(map[string]*harborModel.RobotPermission)
+
+	add := func(namespace string, permission *harborModel.RobotPermission) {
+		if existing, ok := byNamespace[namespace]; ok {
+			existing.Access = appendAccess(existing.Access, permission.Access...)
+			return
+		}
+
+		p := &harborModel.RobotPermission{
+			Kind:      permission.Kind,
+			Namespace: namespace,
+			Access:    appendAccess(nil, permission.Access...),
+		}
+		byNamespace[namespace] = p
+		expanded = append(expanded, p)
+	}
+
+	for _, permission := range permissions {
+		switch {
+		case permission.Kind != permissionKindProject:
+			expanded = append(expanded, permission)
+			continue
+		case permission.Namespace == allProjectsNamespace && coverAll,
+			permission.Namespace != allProjectsNamespace && !isNamespacePattern(permission.Namespace):
+			add(permission.Namespace, permission)
+			continue
+		}
+
+		match := func(string) bool { return true }
+		if permission.Namespace != allProjectsNamespace {
+			var err error
+			if match, err = compileNamespacePattern(permission.Namespace); err != nil {
+				return nil, err
+			}
+		}
+
+		if !listed {
+			var err error
+			if projects, err = c.listProjects(ctx); err != nil {
+				return nil, fmt.Errorf("error listing Harbor projects: %w", err)
+			}
+			listed = true
+		}
+
+		matched := 0
+		for _, project := range projects {
+			if match(project.Name) {
+				add(project.Name, permission)
+				matched++
+			}
+		}
+
+		if matched == 0 {
+			c.logger.Warn("namespace pattern matches no Harbor project", "namespace", permission.Namespace)
+		}
+	}
+
+	if len(permissions) > 0 && len(expanded) == 0 {
+		return nil, fmt.Errorf("the namespaces of the role permissions match no Harbor project")
+	}
+
+	return expanded, nil
+}
+
+// appendAccess appends the accesses missing from the list.
+func appendAccess(list []*harborModel.Access, accesses ...*harborModel.Access) []*harborModel.Access {
+	for _, access := range accesses {
+		duplicate := false
+		for _, a := range list {
+			if *a == *access {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			list = append(list, access)
+		}
+	}
+
+	return list
+}
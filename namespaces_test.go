@@ -0,0 +1,188 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileNamespacePattern(t *testing.T) {
+	tests := []struct {
+		namespace string
+		project   string
+		matched   bool
+	}{
+		{"team-a-*", "team-a-api", true},
+		{"team-a-*", "team-b-api", false},
+		{"team-?-api", "team-b-api", true},
+		{"regex:team-(a|b)-api", "team-b-api", true},
+		{"regex:team-(a|b)", "team-b-api", false},
+	}
+
+	for _, tt := range tests {
+		match, err := compileNamespacePattern(tt.namespace)
+		require.NoError(t, err)
+		require.Equal(t, tt.matched, match(tt.project), "%s on %s", tt.namespace, tt.project)
+	}
+
+	_, err := compileNamespacePattern("team-[a")
+	require.Error(t, err)
+	_, err = compileNamespacePattern("regex:team-(a")
+	require.Error(t, err)
+
+	require.False(t, isNamespacePattern("*"))
+	require.False(t, isNamespacePattern("library"))
+	require.True(t, isNamespacePattern("team-*"))
+}
+
+// TestExpandNamespaces uses a fake Harbor API to check that
+// namespace patterns are expanded to the matching projects.
+func TestExpandNamespaces(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	client, err := b.getClient(context.Background(), s, defaultConnection)
+	require.NoError(t, err)
+
+	pull := &harborModel.Access{Resource: "repository", Action: "pull"}
+	push := &harborModel.Access{Resource: "repository", Action: "push"}
+	permission := func(namespace string, access ...*harborModel.Access) *harborModel.RobotPermission {
+		return &harborModel.RobotPermission{Kind: permissionKindProject, Namespace: namespace, Access: access}
+	}
+	namespaces := func(permissions []*harborModel.RobotPermission) []string {
+		var ns []string
+		for _, p := range permissions {
+			ns = append(ns, p.Namespace)
+		}
+		return ns
+	}
+
+	t.Run("glob", func(t *testing.T) {
+		expanded, err := expandNamespaces(context.Background(), client,
+			[]*harborModel.RobotPermission{permission("team-a-*", pull)}, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a-api", "team-a-web"}, namespaces(expanded))
+	})
+
+	t.Run("regex merged with literal", func(t *testing.T) {
+		expanded, err := expandNamespaces(context.Background(), client, []*harborModel.RobotPermission{
+			permission("team-b-api", push),
+			permission("regex:team-.-api", pull),
+		}, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-b-api", "team-a-api"}, namespaces(expanded))
+		require.Equal(t, []*harborModel.Access{push, pull}, expanded[0].Access)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		_, err := expandNamespaces(context.Background(), client,
+			[]*harborModel.RobotPermission{permission("team-c-*", pull)}, true)
+		require.Error(t, err)
+	})
+
+	t.Run("cover all projects", func(t *testing.T) {
+		expanded, err := expandNamespaces(context.Background(), client,
+			[]*harborModel.RobotPermission{permission("*", pull)}, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"*"}, namespaces(expanded))
+	})
+
+	t.Run("all projects expanded", func(t *testing.T) {
+		expanded, err := expandNamespaces(context.Background(), client,
+			[]*harborModel.RobotPermission{permission("*", pull)}, false)
+		require.NoError(t, err)
+		require.Equal(t, harborServer.Projects, namespaces(expanded))
+	})
+
+	t.Run("all projects on old Harbor", func(t *testing.T) {
+		oldClient := *client
+		oldClient.capabilities = newHarborCapabilities("v2.4.2")
+
+		expanded, err := expandNamespaces(context.Background(), &oldClient,
+			[]*harborModel.RobotPermission{permission("*", pull)}, true)
+		require.NoError(t, err)
+		require.Equal(t, harborServer.Projects, namespaces(expanded))
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		harborServer.Projects = nil
+		for i := 0; i < listProjectsPageSize+5; i++ {
+			harborServer.Projects = append(harborServer.Projects, fmt.Sprintf("project-%d", i))
+		}
+
+		projects, err := client.listProjects(context.Background())
+		require.NoError(t, err)
+		require.Len(t, projects, listProjectsPageSize+5)
+	})
+}
+
+func TestResolveNamespaceTemplates(t *testing.T) {
+	entity := &logical.Entity{
+		ID:       "5a4f3c1e",
+		Name:     "jane",
+		Metadata: map[string]string{"team": "team-a", "widen": "*"},
+		Aliases: []*logical.Alias{
+			{MountAccessor: "auth_oidc_1234", Name: "jane.doe"},
+		},
+	}
+	groups := []*logical.Group{{ID: "g1", Name: "developers"}}
+
+	pull := []*harborModel.Access{{Resource: "repository", Action: "pull"}}
+	permissions := func(namespaces ...string) []*harborModel.RobotPermission {
+		var list []*harborModel.RobotPermission
+		for _, ns := range namespaces {
+			list = append(list, &harborModel.RobotPermission{Kind: permissionKindProject, Namespace: ns, Access: pull})
+		}
+		return list
+	}
+
+	t.Run("entity metadata and alias", func(t *testing.T) {
+		resolved, err := resolveNamespaceTemplates(
+			permissions("library", "{{identity.entity.metadata.team}}-api", "{{identity.entity.aliases.auth_oidc_1234.name}}"),
+			entity, groups)
+		require.NoError(t, err)
+		require.Equal(t, "library", resolved[0].Namespace)
+		require.Equal(t, "team-a-api", resolved[1].Namespace)
+		require.Equal(t, "jane.doe", resolved[2].Namespace)
+	})
+
+	t.Run("the role is left untouched", func(t *testing.T) {
+		role := permissions("{{identity.entity.metadata.team}}")
+		_, err := resolveNamespaceTemplates(role, entity, groups)
+		require.NoError(t, err)
+		require.Equal(t, "{{identity.entity.metadata.team}}", role[0].Namespace)
+	})
+
+	t.Run("missing metadata", func(t *testing.T) {
+		_, err := resolveNamespaceTemplates(permissions("{{identity.entity.metadata.department}}"), entity, groups)
+		require.Error(t, err)
+	})
+
+	t.Run("no entity", func(t *testing.T) {
+		_, err := resolveNamespaceTemplates(permissions("{{identity.entity.metadata.team}}"), nil, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("cannot widen to all projects", func(t *testing.T) {
+		_, err := resolveNamespaceTemplates(permissions("{{identity.entity.metadata.widen}}"), entity, groups)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not a valid project name")
+	})
+
+	t.Run("syntax", func(t *testing.T) {
+		require.NoError(t, checkNamespaceTemplate("{{identity.entity.metadata.team}}"))
+		require.Error(t, checkNamespaceTemplate("{{identity.entity.metadata.team"))
+	})
+}
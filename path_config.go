@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -16,19 +20,61 @@ const (
 	// pathConfigHelpDescription describes the help text for the configuration
 	pathConfigHelpDescription = `
 The Harbor secret backend requires credentials for managing robot accounts.
-You must create a username and password and
+You must create a username and password, or a system robot account
+allowed to manage robot accounts, and
 specify the Harbor address for the products API
 before using this secrets backend.
+
+"config" holds the default connection. Additional Harbor instances
+can be configured as named connections with "config/<connection>",
+and selected by roles with their "connection" field.
 `
+	pathConfigListHelpSynopsis    = `List the named Harbor connections of the backend.`
+	pathConfigListHelpDescription = `Named connections will be listed by their name. The default connection is not listed.`
+
 	configStoragePath = "config"
+
+	// defaultConnection is the name of the connection configured with "config"
+	defaultConnection = ""
+
+	defaultRequestTimeout  = 30 * time.Second
+	defaultMaxRetries      = 3
+	defaultRetryMinBackoff = 1 * time.Second
+	defaultRetryMaxBackoff = 10 * time.Second
+
+	authTypeUser  = "user"
+	authTypeRobot = "robot"
 )
 
 // harborConfig includes the minimum configuration
 // required to instantiate a new Harbor client.
 type harborConfig struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	URL      string `json:"url"`
+	AuthType           string `json:"auth_type"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	RobotName          string `json:"robot_name"`
+	RobotSecret        string `json:"robot_secret"`
+	URL                string `json:"url"`
+	CACert             string `json:"ca_cert"`
+	ClientCert         string `json:"client_cert"`
+	ClientKey          string `json:"client_key"`
+	TLSServerName      string `json:"tls_server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	RequestTimeout  time.Duration `json:"request_timeout"`
+	MaxRetries      int           `json:"max_retries"`
+	RetryMinBackoff time.Duration `json:"retry_min_backoff"`
+	RetryMaxBackoff time.Duration `json:"retry_max_backoff"`
+
+	// NameTemplate is the default robot account name
+	// template of the roles using the connection.
+	NameTemplate string `json:"name_template"`
+
+	// Details about the Harbor instance, detected
+	// when the connection is verified.
+	HarborVersion  string `json:"harbor_version"`
+	HarborAuthMode string `json:"harbor_auth_mode"`
+	Sysadmin       bool   `json:"sysadmin"`
 }
 
 // pathConfig extends the Vault API with a `/config`
@@ -39,12 +85,25 @@ type harborConfig struct {
 // when you read the configuration.
 func pathConfig(b *harborBackend) *framework.Path {
 	return &framework.Path{
-		Pattern: "config",
+		Pattern: "config(/" + framework.GenericNameRegex("connection") + ")?",
 		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the connection, the default connection is used when omitted",
+			},
+			"auth_type": {
+				Type:          framework.TypeString,
+				Description:   "How the backend authenticates to Harbor: with a Harbor user (user) or a system robot account (robot)",
+				Default:       authTypeUser,
+				AllowedValues: []interface{}{authTypeUser, authTypeRobot},
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Authentication type",
+					Sensitive: false,
+				},
+			},
 			"username": {
 				Type:        framework.TypeString,
-				Description: "The username to access Harbor Product API",
-				Required:    true,
+				Description: "The username to access Harbor Product API, required when auth_type is user",
 				DisplayAttrs: &framework.DisplayAttributes{
 					Name:      "Username",
 					Sensitive: false,
@@ -52,13 +111,28 @@ func pathConfig(b *harborBackend) *framework.Path {
 			},
 			"password": {
 				Type:        framework.TypeString,
-				Description: "The user's password to access Harbor Product API",
-				Required:    true,
+				Description: "The user's password to access Harbor Product API, required when auth_type is user",
 				DisplayAttrs: &framework.DisplayAttributes{
 					Name:      "Password",
 					Sensitive: true,
 				},
 			},
+			"robot_name": {
+				Type:        framework.TypeString,
+				Description: "The full name (e.g. robot$vault) of the system robot account, required when auth_type is robot",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Robot name",
+					Sensitive: false,
+				},
+			},
+			"robot_secret": {
+				Type:        framework.TypeString,
+				Description: "The secret of the system robot account, required when auth_type is robot",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Robot secret",
+					Sensitive: true,
+				},
+			},
 			"url": {
 				Type:        framework.TypeString,
 				Description: "The URL for the Harbor Product API",
@@ -68,6 +142,96 @@ func pathConfig(b *harborBackend) *framework.Path {
 					Sensitive: false,
 				},
 			},
+			"ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA bundle used to verify the Harbor server certificate",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "CA certificate",
+					Sensitive: false,
+				},
+			},
+			"client_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded client certificate presented to Harbor for mutual TLS",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Client certificate",
+					Sensitive: false,
+				},
+			},
+			"client_key": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded private key of the client certificate",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Client key",
+					Sensitive: true,
+				},
+			},
+			"tls_server_name": {
+				Type:        framework.TypeString,
+				Description: "Server name used to verify the Harbor server certificate, if it differs from the URL host",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "TLS server name",
+					Sensitive: false,
+				},
+			},
+			"insecure_skip_verify": {
+				Type:        framework.TypeBool,
+				Description: "Skip verification of the Harbor server certificate. Not recommended outside of testing",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Insecure skip verify",
+					Sensitive: false,
+				},
+			},
+			"request_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout of a single call to the Harbor API",
+				Default:     int(defaultRequestTimeout.Seconds()),
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Request timeout",
+				},
+			},
+			"max_retries": {
+				Type:        framework.TypeInt,
+				Description: "Number of times a call to the Harbor API is retried on server errors, rate limiting and connection errors",
+				Default:     defaultMaxRetries,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Max retries",
+				},
+			},
+			"retry_min_backoff": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Time to wait before the first retry, doubled on each further retry",
+				Default:     int(defaultRetryMinBackoff.Seconds()),
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Retry min backoff",
+				},
+			},
+			"retry_max_backoff": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum time to wait between two retries",
+				Default:     int(defaultRetryMaxBackoff.Seconds()),
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Retry max backoff",
+				},
+			},
+			"name_template": {
+				Type: framework.TypeString,
+				Description: "Default template of the robot account names for the roles using this connection, " +
+					"using the Vault username template language with .RoleName, .DisplayName and .EntityID",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Robot name template",
+				},
+			},
+			"verify_connection": {
+				Type:        framework.TypeBool,
+				Description: "Verify the connection and the credentials against Harbor before storing the configuration",
+				Default:     true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:      "Verify connection",
+					Sensitive: false,
+				},
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -89,6 +253,21 @@ func pathConfig(b *harborBackend) *framework.Path {
 	}
 }
 
+// pathConfigList extends the Vault API with a `/config/`
+// endpoint listing the named connections.
+func pathConfigList(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathConfigList,
+			},
+		},
+		HelpSynopsis:    pathConfigListHelpSynopsis,
+		HelpDescription: pathConfigListHelpDescription,
+	}
+}
+
 // pathConfigExistenceCheck verifies if the configuration exists.
 func (b *harborBackend) pathConfigExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 	out, err := req.Storage.Get(ctx, req.Path)
@@ -101,22 +280,42 @@ func (b *harborBackend) pathConfigExistenceCheck(ctx context.Context, req *logic
 
 // pathConfigRead reads the configuration and outputs non-sensitive information.
 func (b *harborBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+	config, err := getConfig(ctx, req.Storage, data.Get("connection").(string))
 	if err != nil {
 		return nil, err
 	}
 
+	if config == nil {
+		return nil, nil
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"username": config.Username,
-			"url":      config.URL,
+			"auth_type":            config.AuthType,
+			"username":             config.Username,
+			"robot_name":           config.RobotName,
+			"url":                  config.URL,
+			"ca_cert":              config.CACert,
+			"client_cert":          config.ClientCert,
+			"tls_server_name":      config.TLSServerName,
+			"insecure_skip_verify": config.InsecureSkipVerify,
+			"request_timeout":      int64(config.RequestTimeout.Seconds()),
+			"max_retries":          config.MaxRetries,
+			"retry_min_backoff":    int64(config.RetryMinBackoff.Seconds()),
+			"retry_max_backoff":    int64(config.RetryMaxBackoff.Seconds()),
+			"name_template":        config.NameTemplate,
+			"harbor_version":       config.HarborVersion,
+			"harbor_auth_mode":     config.HarborAuthMode,
+			"sysadmin":             config.Sysadmin,
 		},
 	}, nil
 }
 
 // pathConfigWrite updates the configuration for the backend
 func (b *harborBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+	connection := data.Get("connection").(string)
+
+	config, err := getConfig(ctx, req.Storage, connection)
 	if err != nil {
 		return nil, err
 	}
@@ -130,10 +329,18 @@ func (b *harborBackend) pathConfigWrite(ctx context.Context, req *logical.Reques
 		config = new(harborConfig)
 	}
 
+	if authType, ok := data.GetOk("auth_type"); ok {
+		config.AuthType = authType.(string)
+	} else if createOperation {
+		config.AuthType = data.Get("auth_type").(string)
+	}
+
+	if config.AuthType != authTypeUser && config.AuthType != authTypeRobot {
+		return logical.ErrorResponse("invalid auth_type %q, must be %q or %q", config.AuthType, authTypeUser, authTypeRobot), nil
+	}
+
 	if username, ok := data.GetOk("username"); ok {
 		config.Username = username.(string)
-	} else if !ok && createOperation {
-		return nil, fmt.Errorf("missing username in configuration")
 	}
 
 	if url, ok := data.GetOk("url"); ok {
@@ -144,38 +351,274 @@ func (b *harborBackend) pathConfigWrite(ctx context.Context, req *logical.Reques
 
 	if password, ok := data.GetOk("password"); ok {
 		config.Password = password.(string)
-	} else if !ok && createOperation {
-		return nil, fmt.Errorf("missing password in configuration")
 	}
 
-	entry, err := logical.StorageEntryJSON(configStoragePath, config)
-	if err != nil {
-		return nil, err
+	if robotName, ok := data.GetOk("robot_name"); ok {
+		config.RobotName = robotName.(string)
+	}
+
+	if robotSecret, ok := data.GetOk("robot_secret"); ok {
+		config.RobotSecret = robotSecret.(string)
+	}
+
+	switch config.AuthType {
+	case authTypeUser:
+		if _, ok := data.GetOk("username"); (!ok && createOperation) || config.Username == "" {
+			return nil, fmt.Errorf("missing username in configuration")
+		}
+		if _, ok := data.GetOk("password"); (!ok && createOperation) || config.Password == "" {
+			return nil, fmt.Errorf("missing password in configuration")
+		}
+	case authTypeRobot:
+		if _, ok := data.GetOk("robot_name"); (!ok && createOperation) || config.RobotName == "" {
+			return nil, fmt.Errorf("missing robot_name in configuration")
+		}
+		if _, ok := data.GetOk("robot_secret"); (!ok && createOperation) || config.RobotSecret == "" {
+			return nil, fmt.Errorf("missing robot_secret in configuration")
+		}
+	}
+
+	if caCert, ok := data.GetOk("ca_cert"); ok {
+		config.CACert = caCert.(string)
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if clientCert, ok := data.GetOk("client_cert"); ok {
+		config.ClientCert = clientCert.(string)
+	}
+
+	if clientKey, ok := data.GetOk("client_key"); ok {
+		config.ClientKey = clientKey.(string)
+	}
+
+	if tlsServerName, ok := data.GetOk("tls_server_name"); ok {
+		config.TLSServerName = tlsServerName.(string)
+	}
+
+	if insecureSkipVerify, ok := data.GetOk("insecure_skip_verify"); ok {
+		config.InsecureSkipVerify = insecureSkipVerify.(bool)
+	}
+
+	if requestTimeout, ok := data.GetOk("request_timeout"); ok {
+		config.RequestTimeout = time.Duration(requestTimeout.(int)) * time.Second
+	} else if createOperation {
+		config.RequestTimeout = time.Duration(data.Get("request_timeout").(int)) * time.Second
+	}
+
+	if maxRetries, ok := data.GetOk("max_retries"); ok {
+		config.MaxRetries = maxRetries.(int)
+	} else if createOperation {
+		config.MaxRetries = data.Get("max_retries").(int)
+	}
+
+	if retryMinBackoff, ok := data.GetOk("retry_min_backoff"); ok {
+		config.RetryMinBackoff = time.Duration(retryMinBackoff.(int)) * time.Second
+	} else if createOperation {
+		config.RetryMinBackoff = time.Duration(data.Get("retry_min_backoff").(int)) * time.Second
+	}
+
+	if retryMaxBackoff, ok := data.GetOk("retry_max_backoff"); ok {
+		config.RetryMaxBackoff = time.Duration(retryMaxBackoff.(int)) * time.Second
+	} else if createOperation {
+		config.RetryMaxBackoff = time.Duration(data.Get("retry_max_backoff").(int)) * time.Second
+	}
+
+	if nameTemplate, ok := data.GetOk("name_template"); ok {
+		config.NameTemplate = nameTemplate.(string)
+	}
+
+	if config.NameTemplate != "" {
+		if err := checkNameTemplate(config.NameTemplate); err != nil {
+			return logical.ErrorResponse("invalid name_template: %s", err.Error()), nil
+		}
+	}
+
+	if config.RequestTimeout <= 0 {
+		return logical.ErrorResponse("request_timeout must be greater than 0"), nil
+	}
+
+	if config.MaxRetries < 0 {
+		return logical.ErrorResponse("max_retries cannot be negative"), nil
+	}
+
+	if config.RetryMinBackoff <= 0 || config.RetryMaxBackoff < config.RetryMinBackoff {
+		return logical.ErrorResponse("retry_min_backoff must be greater than 0 and not greater than retry_max_backoff"), nil
+	}
+
+	if _, err := newTLSConfig(config); err != nil {
+		return logical.ErrorResponse("invalid TLS configuration: %s", err.Error()), nil
+	}
+
+	// the details about the Harbor instance are only
+	// kept as long as they have been verified
+	config.HarborVersion = ""
+	config.HarborAuthMode = ""
+	config.Sysadmin = false
+
+	if data.Get("verify_connection").(bool) {
+		if err := verifyConnection(ctx, config, b.Logger()); err != nil {
+			return logical.ErrorResponse("error verifying the connection to Harbor: %s", err.Error()), nil
+		}
+	}
+
+	if err := putConfig(ctx, req.Storage, connection, config); err != nil {
 		return nil, err
 	}
 
 	// reset the client so the next invocation will pick up the new configuration
-	b.reset()
+	b.reset(connection)
 
 	return nil, nil
 }
 
 // pathConfigDelete removes the configuration for the backend
 func (b *harborBackend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, configStoragePath)
+	connection := data.Get("connection").(string)
+
+	err := req.Storage.Delete(ctx, configStorageKey(connection))
 
 	if err == nil {
-		b.reset()
+		b.reset(connection)
 	}
 
 	return nil, err
 }
 
-func getConfig(ctx context.Context, s logical.Storage) (*harborConfig, error) {
-	entry, err := s.Get(ctx, configStoragePath)
+// pathConfigList makes a request to Vault storage to retrieve the list of named connections
+func (b *harborBackend) pathConfigList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, configStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// verifyConnection makes authenticated calls to Harbor with the
+// configuration and records the details about the Harbor instance into it.
+func verifyConnection(ctx context.Context, config *harborConfig, logger hclog.Logger) error {
+	client, err := newClient(config, logger)
+	if err != nil {
+		return err
+	}
+
+	info, err := client.getSystemInfo(ctx)
+	if err != nil {
+		return describeVerifyError("reading the system information", err)
+	}
+
+	sysadmin := false
+
+	switch config.AuthType {
+	case authTypeRobot:
+		robot, err := client.getRobotByName(ctx, config.RobotName)
+		if err != nil {
+			return describeVerifyError("looking up the robot account", err)
+		}
+		if robot == nil {
+			return fmt.Errorf("robot account %q not found in Harbor", config.RobotName)
+		}
+	default:
+		user, err := client.getCurrentUser(ctx)
+		if err != nil {
+			return describeVerifyError("reading the current user", err)
+		}
+		sysadmin = user.SysadminFlag
+	}
+
+	config.HarborVersion = info.HarborVersion
+	config.HarborAuthMode = info.AuthMode
+	config.Sysadmin = sysadmin
+
+	return nil
+}
+
+// describeVerifyError turns a failed verification call into a
+// message pointing at the most likely misconfiguration.
+func describeVerifyError(call string, err error) error {
+	var apiErr *harborAPIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%s failed, Harbor rejected the credentials: %w", call, err)
+		case http.StatusForbidden:
+			return fmt.Errorf("%s failed, the account is missing permissions: %w", call, err)
+		case http.StatusNotFound:
+			return fmt.Errorf("%s failed, check that the URL points to Harbor: %w", call, err)
+		}
+	}
+
+	return fmt.Errorf("%s failed: %w", call, err)
+}
+
+// credentials returns the name and the secret
+// the Harbor client authenticates with.
+func (c *harborConfig) credentials() (string, string) {
+	if c.AuthType == authTypeRobot {
+		return c.RobotName, c.RobotSecret
+	}
+
+	return c.Username, c.Password
+}
+
+// reservedConnectionNames are the paths under "config" served by other
+// endpoints, routed before the named connections: a connection can't take
+// their name, its configuration would be unreachable.
+var reservedConnectionNames = []string{"rotate-root", "auto-tidy"}
+
+// checkConnection checks the connection of a role is configured.
+// It returns a user error when it isn't.
+func checkConnection(ctx context.Context, s logical.Storage, connection string) (*logical.Response, error) {
+	if connection == defaultConnection {
+		return nil, nil
+	}
+
+	if slices.Contains(reservedConnectionNames, connection) {
+		return logical.ErrorResponse("connection name %q is reserved", connection), nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("connection %q is not configured, write config/%s first", connection, connection), nil
+	}
+
+	return nil, nil
+}
+
+// configStorageKey returns the storage key of a connection configuration
+func configStorageKey(connection string) string {
+	if connection == defaultConnection {
+		return configStoragePath
+	}
+
+	return configStoragePath + "/" + connection
+}
+
+// connectionDisplayName returns the name of a connection for messages
+func connectionDisplayName(connection string) string {
+	if connection == defaultConnection {
+		return "(default)"
+	}
+
+	return fmt.Sprintf("%q", connection)
+}
+
+// putConfig stores the configuration of a connection into the Vault storage API
+func putConfig(ctx context.Context, s logical.Storage, connection string, config *harborConfig) error {
+	entry, err := logical.StorageEntryJSON(configStorageKey(connection), config)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getConfig gets the configuration of a connection from the Vault storage API
+func getConfig(ctx context.Context, s logical.Storage, connection string) (*harborConfig, error) {
+	entry, err := s.Get(ctx, configStorageKey(connection))
 	if err != nil {
 		return nil, err
 	}
@@ -189,6 +632,21 @@ func getConfig(ctx context.Context, s logical.Storage) (*harborConfig, error) {
 		return nil, fmt.Errorf("error reading root configuration: %w", err)
 	}
 
+	// configurations written before robot authentication was
+	// supported always authenticate with a Harbor user
+	if config.AuthType == "" {
+		config.AuthType = authTypeUser
+	}
+
+	// configurations written before timeouts and retries were
+	// supported get the defaults
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = defaultRequestTimeout
+		config.MaxRetries = defaultMaxRetries
+		config.RetryMinBackoff = defaultRetryMinBackoff
+		config.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+
 	// return the config, we are done
 	return config, nil
 }
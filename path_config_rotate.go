@@ -0,0 +1,160 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathConfigRotateRootHelpSynopsis    = `Rotate the credential of the Harbor account used by the backend.`
+	pathConfigRotateRootHelpDescription = `
+This path generates a new password for the Harbor user configured in "config",
+or in "config/<connection>" when using "config/<connection>/rotate-root",
+changes it in Harbor and stores it into the backend configuration.
+When the backend authenticates with a system robot account, the robot secret
+is refreshed instead. Once rotated, the credential is only known to Vault.
+`
+
+	// generatedPasswordLength is the length of passwords generated for Harbor,
+	// kept below the 20 characters limit of older Harbor releases.
+	generatedPasswordLength = 20
+)
+
+// pathConfigRotateRoot extends the Vault API with a `/config/rotate-root`
+// endpoint for the backend, and `/config/<connection>/rotate-root`
+// for the named connections.
+func pathConfigRotateRoot(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/(" + framework.GenericNameRegex("connection") + "/)?rotate-root",
+		Fields: map[string]*framework.FieldSchema{
+			"connection": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the connection, the default connection is used when omitted",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathConfigRotateRootUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathConfigRotateRootHelpSynopsis,
+		HelpDescription: pathConfigRotateRootHelpDescription,
+	}
+}
+
+// pathConfigRotateRootUpdate changes the password of the configured Harbor user,
+// or the secret of the configured robot account, and persists it into the backend configuration.
+func (b *harborBackend) pathConfigRotateRootUpdate(
+	ctx context.Context,
+	req *logical.Request,
+	d *framework.FieldData,
+) (*logical.Response, error) {
+	connection := d.Get("connection").(string)
+
+	config, err := getConfig(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("harbor connection %s is not configured", connectionDisplayName(connection)), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.AuthType {
+	case authTypeRobot:
+		err = rotateRobotSecret(ctx, client, config)
+	default:
+		err = rotateUserPassword(ctx, client, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putConfig(ctx, req.Storage, connection, config); err != nil {
+		// The credential was changed in Harbor already, so surface
+		// the failure loudly: the stored credential is stale now.
+		return nil, fmt.Errorf("error storing rotated credential, the configuration must be rewritten: %w", err)
+	}
+
+	// reset the client so the next invocation will pick up the new credential
+	b.reset(connection)
+
+	return nil, nil
+}
+
+// rotateUserPassword changes the password of the configured Harbor user.
+func rotateUserPassword(ctx context.Context, client *harborClient, config *harborConfig) error {
+	user, err := client.getCurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving Harbor user: %w", err)
+	}
+
+	newPassword, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	if err := client.updateUserPassword(ctx, user.UserID, config.Password, newPassword); err != nil {
+		return fmt.Errorf("error changing Harbor user password: %w", err)
+	}
+
+	config.Password = newPassword
+
+	return nil
+}
+
+// rotateRobotSecret refreshes the secret of the configured robot account.
+func rotateRobotSecret(ctx context.Context, client *harborClient, config *harborConfig) error {
+	robot, err := client.getRobotByName(ctx, config.RobotName)
+	if err != nil {
+		return fmt.Errorf("error retrieving Harbor robot account: %w", err)
+	}
+
+	if robot == nil {
+		return fmt.Errorf("robot account %q not found in Harbor", config.RobotName)
+	}
+
+	newSecret, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	secret, err := client.refreshRobotSecret(ctx, robot.ID, newSecret)
+	if err != nil {
+		return fmt.Errorf("error refreshing Harbor robot account secret: %w", err)
+	}
+
+	config.RobotSecret = secret
+
+	return nil
+}
+
+// generatePassword generates a random password, or robot secret,
+// matching the Harbor password policy: at least one uppercase letter,
+// one lowercase letter and one digit.
+func generatePassword() (string, error) {
+	for {
+		password, err := base62.Random(generatedPasswordLength)
+		if err != nil {
+			return "", err
+		}
+
+		if strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+			strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") &&
+			strings.ContainsAny(password, "0123456789") {
+			return password, nil
+		}
+	}
+}
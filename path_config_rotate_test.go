@@ -0,0 +1,84 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigRotateRoot uses a fake Harbor API to check
+// that rotating the root credential changes and stores the password.
+func TestConfigRotateRoot(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	t.Run("Rotate without configuration", func(t *testing.T) {
+		resp, err := testConfigRotateRoot(b, reqStorage)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Rotate root", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"username": username,
+			"password": password,
+			"url":      harborServer.URL,
+		})
+		require.NoError(t, err)
+
+		resp, err := testConfigRotateRoot(b, reqStorage)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), reqStorage, defaultConnection)
+		require.NoError(t, err)
+		require.NotEqual(t, password, config.Password)
+		require.Equal(t, harborServer.Password, config.Password)
+
+		// the client must have been reset to authenticate with the new password
+		resp, err = testConfigRotateRoot(b, reqStorage)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	t.Run("Rotate robot secret", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"auth_type":    authTypeRobot,
+			"robot_name":   robotName,
+			"robot_secret": robotSecret,
+			"url":          harborServer.URL,
+		})
+		require.NoError(t, err)
+
+		resp, err := testConfigRotateRoot(b, reqStorage)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		config, err := getConfig(context.Background(), reqStorage, defaultConnection)
+		require.NoError(t, err)
+		require.NotEqual(t, robotSecret, config.RobotSecret)
+		require.Equal(t, harborServer.RobotSecret, config.RobotSecret)
+	})
+}
+
+func TestGeneratePassword(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		p, err := generatePassword()
+		require.NoError(t, err)
+		require.Len(t, p, generatedPasswordLength)
+		require.Regexp(t, "[A-Z]", p)
+		require.Regexp(t, "[a-z]", p)
+		require.Regexp(t, "[0-9]", p)
+	}
+}
+
+func testConfigRotateRoot(b logical.Backend, s logical.Storage) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate-root",
+		Storage:   s,
+	})
+}
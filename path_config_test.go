@@ -7,12 +7,16 @@ import (
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
 	username = "vault-plugin-testing"
 	password = "Testing!123"
 	url      = "http://localhost:1234"
+
+	robotName   = "robot$vault"
+	robotSecret = "Testing!456"
 )
 
 // TestConfig mocks the creation, read, update, and delete
@@ -22,45 +26,180 @@ func TestConfig(t *testing.T) {
 
 	t.Run("Test Configuration", func(t *testing.T) {
 		err := testConfigCreate(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"password": password,
-			"url":      url,
+			"username":          username,
+			"password":          password,
+			"url":               url,
+			"verify_connection": false,
 		})
 		assert.NoError(t, err)
 
 		err = testConfigCreate(b, reqStorage, map[string]interface{}{
-			"password": password,
-			"url":      url,
+			"password":          password,
+			"url":               url,
+			"verify_connection": false,
 		})
 		assert.Error(t, err)
 
 		err = testConfigCreate(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"url":      url,
+			"username":          username,
+			"url":               url,
+			"verify_connection": false,
 		})
 		assert.Error(t, err)
 
 		err = testConfigCreate(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"password": password,
+			"username":          username,
+			"password":          password,
+			"verify_connection": false,
 		})
 		assert.Error(t, err)
 
 		err = testConfigRead(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"url":      url,
+			"auth_type":            authTypeUser,
+			"username":             username,
+			"robot_name":           "",
+			"url":                  url,
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "",
+			"insecure_skip_verify": false,
+			"request_timeout":      int64(30),
+			"max_retries":          3,
+			"retry_min_backoff":    int64(1),
+			"retry_max_backoff":    int64(10),
+			"name_template":        "",
+			"harbor_version":       "",
+			"harbor_auth_mode":     "",
+			"sysadmin":             false,
 		})
 		assert.NoError(t, err)
 
 		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"url":      "http://harbor:1234",
+			"username":          username,
+			"url":               "http://harbor:1234",
+			"verify_connection": false,
 		})
 		assert.NoError(t, err)
 
 		err = testConfigRead(b, reqStorage, map[string]interface{}{
-			"username": username,
-			"url":      "http://harbor:1234",
+			"auth_type":            authTypeUser,
+			"username":             username,
+			"robot_name":           "",
+			"url":                  "http://harbor:1234",
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "",
+			"insecure_skip_verify": false,
+			"request_timeout":      int64(30),
+			"max_retries":          3,
+			"retry_min_backoff":    int64(1),
+			"retry_max_backoff":    int64(10),
+			"name_template":        "",
+			"harbor_version":       "",
+			"harbor_auth_mode":     "",
+			"sysadmin":             false,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"tls_server_name":      "harbor.internal",
+			"insecure_skip_verify": true,
+			"verify_connection":    false,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigRead(b, reqStorage, map[string]interface{}{
+			"auth_type":            authTypeUser,
+			"username":             username,
+			"robot_name":           "",
+			"url":                  "http://harbor:1234",
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "harbor.internal",
+			"insecure_skip_verify": true,
+			"request_timeout":      int64(30),
+			"max_retries":          3,
+			"retry_min_backoff":    int64(1),
+			"retry_max_backoff":    int64(10),
+			"name_template":        "",
+			"harbor_version":       "",
+			"harbor_auth_mode":     "",
+			"sysadmin":             false,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"max_retries":       -1,
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"retry_min_backoff": "1m",
+			"retry_max_backoff": "10s",
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"ca_cert":           "not a certificate",
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"client_key":        "not a key",
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigDelete(b, reqStorage)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test Robot Configuration", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"auth_type":         authTypeRobot,
+			"robot_name":        robotName,
+			"url":               url,
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigCreate(b, reqStorage, map[string]interface{}{
+			"auth_type":         "token",
+			"robot_name":        robotName,
+			"url":               url,
+			"verify_connection": false,
+		})
+		assert.Error(t, err)
+
+		err = testConfigCreate(b, reqStorage, map[string]interface{}{
+			"auth_type":         authTypeRobot,
+			"robot_name":        robotName,
+			"robot_secret":      robotSecret,
+			"url":               url,
+			"verify_connection": false,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigRead(b, reqStorage, map[string]interface{}{
+			"auth_type":            authTypeRobot,
+			"username":             "",
+			"robot_name":           robotName,
+			"url":                  url,
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "",
+			"insecure_skip_verify": false,
+			"request_timeout":      int64(30),
+			"max_retries":          3,
+			"retry_min_backoff":    int64(1),
+			"retry_max_backoff":    int64(10),
+			"name_template":        "",
+			"harbor_version":       "",
+			"harbor_auth_mode":     "",
+			"sysadmin":             false,
 		})
 		assert.NoError(t, err)
 
@@ -69,6 +208,135 @@ func TestConfig(t *testing.T) {
 	})
 }
 
+// TestConfigConnections checks the configuration of
+// named connections next to the default connection.
+func TestConfigConnections(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, connection := range []string{"eu", "us"} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      configStoragePath + "/" + connection,
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"username":          username,
+				"password":          password,
+				"url":               "https://harbor." + connection + ".internal",
+				"verify_connection": false,
+			},
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      configStoragePath + "/",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu", "us"}, resp.Data["keys"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configStoragePath + "/eu",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "https://harbor.eu.internal", resp.Data["url"])
+
+	// the default connection is not configured
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configStoragePath,
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// invalidating a connection only clears its own client
+	b.clients["eu"] = &harborClient{}
+	b.clients["us"] = &harborClient{}
+	b.invalidate(context.Background(), configStoragePath+"/eu")
+	require.NotContains(t, b.clients, "eu")
+	require.Contains(t, b.clients, "us")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      configStoragePath + "/us",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.NotContains(t, b.clients, "us")
+}
+
+// TestConfigVerifyConnection uses a fake Harbor API to check
+// that the configuration is verified before being stored.
+func TestConfigVerifyConnection(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	harborServer := newFakeHarbor(t)
+
+	t.Run("Reject bad credentials", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"username": username,
+			"password": "wrong",
+			"url":      harborServer.URL,
+		})
+		assert.ErrorContains(t, err, "rejected the credentials")
+	})
+
+	t.Run("Reject bad URL", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"username": username,
+			"password": password,
+			"url":      harborServer.URL + "/not-harbor",
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Record Harbor details", func(t *testing.T) {
+		err := testConfigCreate(b, reqStorage, map[string]interface{}{
+			"username": username,
+			"password": password,
+			"url":      harborServer.URL,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigRead(b, reqStorage, map[string]interface{}{
+			"auth_type":            authTypeUser,
+			"username":             username,
+			"robot_name":           "",
+			"url":                  harborServer.URL,
+			"ca_cert":              "",
+			"client_cert":          "",
+			"tls_server_name":      "",
+			"insecure_skip_verify": false,
+			"request_timeout":      int64(30),
+			"max_retries":          3,
+			"retry_min_backoff":    int64(1),
+			"retry_max_backoff":    int64(10),
+			"name_template":        "",
+			"harbor_version":       harborServer.Version,
+			"harbor_auth_mode":     "db_auth",
+			"sysadmin":             true,
+		})
+		assert.NoError(t, err)
+
+		err = testConfigUpdate(b, reqStorage, map[string]interface{}{
+			"auth_type":    authTypeRobot,
+			"robot_name":   robotName,
+			"robot_secret": robotSecret,
+		})
+		assert.NoError(t, err)
+
+		config, err := getConfig(context.Background(), reqStorage, defaultConnection)
+		assert.NoError(t, err)
+		assert.Equal(t, harborServer.Version, config.HarborVersion)
+		assert.False(t, config.Sysadmin)
+	})
+}
+
 func testConfigDelete(b logical.Backend, s logical.Storage) error {
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
 		Operation: logical.DeleteOperation,
@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"regexp"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -42,6 +41,36 @@ func pathCreds(b *harborBackend) *framework.Path {
 				Description: "Name of the role",
 				Required:    true,
 			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "TTL of the lease and the robot account, capped by the max_ttl of the role. If not set, will use the role ttl.",
+			},
+			"projects": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Projects to narrow the robot account permissions to, within those of the role",
+			},
+			"actions": {
+				Type: framework.TypeCommaStringSlice,
+				Description: "Actions to narrow the robot account permissions to, within those of the role, " +
+					"as <action> or <resource>:<action>",
+			},
+			"output_format": {
+				Type:          framework.TypeString,
+				Description:   "Output format of the credentials, default or dockerconfigjson. If not set, will use the role output_format.",
+				AllowedValues: []interface{}{outputFormatDefault, outputFormatDockerConfigJSON},
+			},
+			"registry_host": {
+				Type:        framework.TypeString,
+				Description: "Registry host of the docker config JSON document, with an optional port. If not set, will use the role registry_host.",
+			},
+			"kubernetes_secret_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the Kubernetes Secret manifest to return with the docker config JSON document",
+			},
+			"kubernetes_namespace": {
+				Type:        framework.TypeString,
+				Description: "Namespace of the Kubernetes Secret manifest",
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation:   b.pathCredsRead,
@@ -66,47 +95,257 @@ func (b *harborBackend) pathCredsRead(ctx context.Context, req *logical.Request,
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
-	return b.createCreds(ctx, req, roleName, roleEntry)
+	permissions, err := b.resolvePermissionTemplates(req.EntityID, roleEntry.Permissions)
+	if err != nil {
+		return logical.ErrorResponse("unable to issue credentials: %s", err.Error()), nil
+	}
+	roleEntry.Permissions = permissions
+
+	scope := credsScope{
+		Projects: d.Get("projects").([]string),
+		Actions:  d.Get("actions").([]string),
+	}
+
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	if ttl < 0 {
+		return logical.ErrorResponse("ttl cannot be negative"), nil
+	}
+
+	output, resp, err := b.credsOutput(ctx, req, roleEntry, d)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	var warning string
+	if maxTTL := b.roleMaxTTL(roleEntry); ttl > maxTTL {
+		warning = fmt.Sprintf("ttl of %s is greater than the max_ttl of the role, it was capped to %s", ttl, maxTTL)
+		ttl = maxTTL
+	}
+
+	resp, err = b.createCreds(ctx, req, roleName, roleEntry, ttl, scope, output)
+	if err != nil || resp.IsError() || warning == "" {
+		return resp, err
+	}
+
+	resp.AddWarning(warning)
+
+	return resp, nil
+}
+
+// credsOutput returns how the credentials are output in the dockerconfigjson
+// output format, from the request and the role, or nil in the default format.
+func (b *harborBackend) credsOutput(
+	ctx context.Context,
+	req *logical.Request,
+	roleEntry *harborRoleEntry,
+	d *framework.FieldData,
+) (*dockerConfigOutput, *logical.Response, error) {
+	outputFormat := roleEntry.outputFormat()
+	if format, ok := d.GetOk("output_format"); ok {
+		outputFormat = format.(string)
+	}
+
+	output := &dockerConfigOutput{
+		RegistryHost:        roleEntry.RegistryHost,
+		KubernetesName:      d.Get("kubernetes_secret_name").(string),
+		KubernetesNamespace: d.Get("kubernetes_namespace").(string),
+	}
+
+	if outputFormat != outputFormatDockerConfigJSON {
+		if output.KubernetesName != "" || output.KubernetesNamespace != "" {
+			return nil, logical.ErrorResponse("kubernetes_secret_name and kubernetes_namespace are only used when output_format is %s", outputFormatDockerConfigJSON), nil
+		}
+		return nil, nil, nil
+	}
+
+	if output.KubernetesName != "" || output.KubernetesNamespace != "" {
+		if err := validateKubernetesSecret(output.KubernetesName, output.KubernetesNamespace); err != nil {
+			return nil, logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if host, ok := d.GetOk("registry_host"); ok && host.(string) != "" {
+		if err := validateRegistryHost(host.(string)); err != nil {
+			return nil, logical.ErrorResponse(err.Error()), nil
+		}
+		output.RegistryHost = host.(string)
+	}
+
+	if output.RegistryHost != "" {
+		return output, nil, nil
+	}
+
+	config, err := getConfig(ctx, req.Storage, roleEntry.Connection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config == nil {
+		return nil, nil, fmt.Errorf("harbor connection %s is not configured", connectionDisplayName(roleEntry.Connection))
+	}
+
+	if output.RegistryHost, err = registryHost(config.URL); err != nil {
+		return nil, nil, fmt.Errorf("error reading the registry host: %w", err)
+	}
+
+	return output, nil, nil
+}
+
+// roleMaxTTL returns the max TTL of the credentials of a role,
+// the mount max TTL when the role doesn't set one.
+func (b *harborBackend) roleMaxTTL(roleEntry *harborRoleEntry) time.Duration {
+	if roleEntry.MaxTTL > 0 {
+		return roleEntry.MaxTTL
+	}
+
+	return b.System().MaxLeaseTTL()
+}
+
+// resolvePermissionTemplates resolves the identity templates of the permission
+// namespaces with the entity of the request, and the groups it belongs to.
+func (b *harborBackend) resolvePermissionTemplates(
+	entityID string,
+	permissions []*harborModel.RobotPermission,
+) ([]*harborModel.RobotPermission, error) {
+	templated := false
+	for _, permission := range permissions {
+		if permission.Kind == permissionKindProject && isNamespaceTemplate(permission.Namespace) {
+			templated = true
+			break
+		}
+	}
+
+	if !templated {
+		return permissions, nil
+	}
+
+	if entityID == "" {
+		return nil, errors.New("the role permissions use identity templates, but the request has no entity")
+	}
+
+	entity, err := b.System().EntityInfo(entityID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving entity: %w", err)
+	}
+
+	groups, err := b.System().GroupsForEntity(entityID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving entity groups: %w", err)
+	}
+
+	return resolveNamespaceTemplates(permissions, entity, groups)
 }
 
 // createCreds creates a new Harbor robot account to store into the Vault backend, generates
 // a response with the robot account information, and checks the TTL and MaxTTL attributes.
+// The ttl of the request, already capped by the role, is zero to use the role TTLs.
 func (b *harborBackend) createCreds(
 	ctx context.Context,
 	req *logical.Request,
 	roleName string,
 	role *harborRoleEntry,
+	ttl time.Duration,
+	scope credsScope,
+	output *dockerConfigOutput,
 ) (*logical.Response, error) {
-	var displayName string
-
-	if req.DisplayName != "" {
-		re := regexp.MustCompile("[^[:alnum:]._-]")
-		dn := re.ReplaceAllString(req.DisplayName, "-")
-		displayName = fmt.Sprintf("%s.", dn)
+	robotAccountName, resp, err := b.robotAccountName(ctx, req, roleName, role)
+	if resp != nil || err != nil {
+		return resp, err
 	}
 
-	robotAccountName := fmt.Sprintf("vault.%s.%s%d", roleName, displayName, time.Now().UnixNano())
+	// the robot account lives as long as the requested ttl, its renewals
+	// extend it, or it outlives the lease, so that the lease can be renewed
+	// until the role max TTL
+	lifetime := b.roleMaxTTL(role)
+	if ttl > 0 {
+		lifetime = ttl
+	}
 
-	robotAccount, err := b.createRobotAccount(ctx, req.Storage, robotAccountName, role)
+	robotAccount, walID, err := b.createRobotAccount(ctx, req.Storage, robotAccountName, role, scope, lifetime)
+	if errors.Is(err, errOutsideRole) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// The response is divided into two objects (1) internal data and (2) data.
-	resp := b.Secret(harborRobotAccountType).Response(map[string]interface{}{
+	resp = b.Secret(harborRobotAccountType).Response(map[string]interface{}{
 		"robot_account_id":         robotAccount.ID,
 		"robot_account_name":       robotAccount.Name,
 		"robot_account_secret":     robotAccount.Secret,
 		"robot_account_auth_token": robotAccount.AuthToken,
 	}, map[string]interface{}{
 		"role":               roleName,
+		"connection":         role.Connection,
+		"robot_account_id":   robotAccount.ID,
 		"robot_account_name": robotAccountName,
+
+		"revocation_mode":         role.revocationMode(),
+		"revocation_delete_after": int64(role.RevocationDeleteAfter.Seconds()),
 	})
 
+	if output != nil {
+		// the robot account is rolled back when the output fails
+		outputData, err := output.toResponseData(robotAccount)
+		if err != nil {
+			return nil, fmt.Errorf("error building the %s output: %w", outputFormatDockerConfigJSON, err)
+		}
+
+		for key, value := range outputData {
+			resp.Data[key] = value
+		}
+	}
+
 	if role.TTL > 0 {
 		resp.Secret.TTL = role.TTL
 	}
 
+	if ttl > 0 {
+		resp.Secret.TTL = ttl
+	}
+
+	leaseTTL := resp.Secret.TTL
+	if leaseTTL <= 0 {
+		leaseTTL = b.System().DefaultLeaseTTL()
+	}
+	if maxTTL := b.roleMaxTTL(role); leaseTTL > maxTTL {
+		leaseTTL = maxTTL
+	}
+
+	now := time.Now()
+
+	err = putRobotRecord(ctx, req.Storage, robotAccountName, &harborRobotRecord{
+		ID:         robotAccount.ID,
+		Name:       robotAccount.Name,
+		Connection: role.Connection,
+		Role:       roleName,
+		EntityID:   req.EntityID,
+		Projects:   scope.Projects,
+		Actions:    scope.Actions,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(leaseTTL),
+
+		ScopeRecorded:         true,
+		RevocationMode:        role.revocationMode(),
+		RevocationDeleteAfter: role.RevocationDeleteAfter,
+	})
+	if err != nil {
+		// without its record, nothing would delete the robot account
+		// if its lease isn't revoked, so don't hand it out
+		if client, clientErr := b.getClient(ctx, req.Storage, role.Connection); clientErr == nil {
+			if deleteErr := deleteRobotAccount(ctx, client, robotAccount.ID, robotAccountName); deleteErr != nil {
+				b.Logger().Error("error deleting unrecorded robot account", "robot_account", robotAccount.Name, "error", deleteErr)
+			}
+		}
+		return nil, fmt.Errorf("error recording robot account: %w", err)
+	}
+
+	// the robot account is recorded, it doesn't need a rollback anymore
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, fmt.Errorf("error committing robot account: %w", err)
+	}
+
 	if role.MaxTTL > 0 {
 		resp.Secret.MaxTTL = role.MaxTTL
 	}
@@ -114,32 +353,93 @@ func (b *harborBackend) createCreds(
 	return resp, nil
 }
 
-// createRobotAccount uses the Harbor client to create and return a robot account
+// robotAccountName renders the name of a new robot account from the name template
+// of the role, or the one of its connection. A template which can't render a valid
+// name is reported in the response, as a user error.
+func (b *harborBackend) robotAccountName(
+	ctx context.Context,
+	req *logical.Request,
+	roleName string,
+	role *harborRoleEntry,
+) (string, *logical.Response, error) {
+	nameTemplate := role.NameTemplate
+
+	if nameTemplate == "" {
+		config, err := getConfig(ctx, req.Storage, role.Connection)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if config != nil {
+			nameTemplate = config.NameTemplate
+		}
+	}
+
+	name, err := renderRobotName(nameTemplate, robotNameData{
+		RoleName:    roleName,
+		DisplayName: sanitizeDisplayName(req.DisplayName),
+		EntityID:    req.EntityID,
+	})
+	if err != nil {
+		return "", logical.ErrorResponse(err.Error()), nil
+	}
+
+	return name, nil, nil
+}
+
+// createRobotAccount uses the Harbor client to create and return a robot account living
+// at least the given lifetime, with the ID of the write-ahead log entry rolling it back
+// until it is recorded.
 func (b *harborBackend) createRobotAccount(
 	ctx context.Context,
 	s logical.Storage,
 	robotName string,
 	roleEntry *harborRoleEntry,
-) (*harborRobotAccount, error) {
-	client, err := b.getClient(ctx, s)
+	scope credsScope,
+	lifetime time.Duration,
+) (*harborRobotAccount, string, error) {
+	client, err := b.getClient(ctx, s, roleEntry.Connection)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	maxTTLByDay := int64(roleEntry.MaxTTL.Hours()/dayHours) + 1
+	permissions, err := buildRobotPermissions(ctx, client, roleEntry, scope)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating Harbor robot account: %w", err)
+	}
+
+	if err := client.capabilities.checkPermissions(permissions); err != nil {
+		return nil, "", fmt.Errorf("error creating Harbor robot account: %w", err)
+	}
 
 	robotCreate := &harborModel.RobotCreate{
 		Name:        robotName,
-		Description: "This robot account is created by Vault, please DO NOT edit!",
+		Description: robotDescription(b.backendUUID),
 		Disable:     false,
-		Duration:    maxTTLByDay,
-		Level:       "system",
-		Permissions: roleEntry.Permissions,
+		Duration:    robotDurationDays(lifetime),
+		Level:       roleEntry.robotLevel(),
+		Permissions: permissions,
 	}
 
-	robotCreated, err := client.RESTClient.NewRobotAccount(ctx, robotCreate)
+	// the robot account may be created in Harbor even if the call fails,
+	// the write-ahead log entry deletes it when it isn't recorded
+	walID, err := framework.PutWAL(ctx, s, walRobotAccountKind, &walRobotAccount{
+		Connection: roleEntry.Connection,
+		RobotName:  robotName,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error writing write-ahead log entry: %w", err)
+	}
+
+	var robotCreated *harborModel.RobotCreated
+	err = client.callNonIdempotent(ctx, "create robot account", func(ctx context.Context) error {
+		var err error
+		robotCreated, err = client.RESTClient.NewRobotAccount(ctx, robotCreate)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating Harbor robot account: %w", err)
+		return nil, "", fmt.Errorf("error creating Harbor robot account: %w", err)
 	}
 
 	robotToken := fmt.Sprintf("%s:%s", robotCreated.Name, robotCreated.Secret)
@@ -151,5 +451,49 @@ func (b *harborBackend) createRobotAccount(
 		AuthToken: base64.StdEncoding.EncodeToString([]byte(robotToken)),
 	}
 
-	return robotAccount, nil
+	return robotAccount, walID, nil
+}
+
+// robotDurationDays returns the duration, in days, of a robot account
+// living at least the given lifetime.
+func robotDurationDays(lifetime time.Duration) int64 {
+	return int64(lifetime.Hours()/dayHours) + 1
+}
+
+// buildRobotPermissions returns the permissions of a new robot account of a role:
+// the permissions of the role, with their namespace patterns expanded, narrowed
+// to the scope of the credentials request.
+func buildRobotPermissions(
+	ctx context.Context,
+	client *harborClient,
+	roleEntry *harborRoleEntry,
+	scope credsScope,
+) ([]*harborModel.RobotPermission, error) {
+	permissions, err := expandNamespaces(ctx, client, robotPermissions(roleEntry), roleEntry.coverAllProjects())
+	if err != nil {
+		return nil, err
+	}
+
+	return narrowPermissions(permissions, scope)
+}
+
+// robotPermissions returns the permissions of the robot accounts of a role.
+// Harbor only takes a single permission, on the robot project, for project
+// robot accounts, so the accesses of the role are merged into it.
+func robotPermissions(roleEntry *harborRoleEntry) []*harborModel.RobotPermission {
+	if roleEntry.robotLevel() != robotLevelProject {
+		return roleEntry.Permissions
+	}
+
+	permission := &harborModel.RobotPermission{
+		Kind:      permissionKindProject,
+		Namespace: roleEntry.Project,
+		Access:    []*harborModel.Access{},
+	}
+
+	for _, p := range roleEntry.Permissions {
+		permission.Access = append(permission.Access, p.Access...)
+	}
+
+	return []*harborModel.RobotPermission{permission}
 }
@@ -10,6 +10,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
 )
 
 // newAcceptanceTestEnv creates a test environment for credentials
@@ -60,3 +61,42 @@ func TestAcceptanceRobotAccount(t *testing.T) {
 	t.Run("renew cred", acceptanceTestEnv.RenewRobotAccount)
 	t.Run("cleanup robot accounts", acceptanceTestEnv.CleanupRobotAccounts)
 }
+
+func TestRobotPermissions(t *testing.T) {
+	permissions, err := parsePermissions([]interface{}{
+		`[
+			{"kind":"project","namespace":"public","access":[{"resource":"repository","action":"pull"}]},
+			{"kind":"project","namespace":"public","access":[{"resource":"artifact","action":"read"}]}
+		]`,
+	})
+	require.NoError(t, err)
+
+	t.Run("system level", func(t *testing.T) {
+		role := &harborRoleEntry{Permissions: permissions}
+		require.Equal(t, permissions, robotPermissions(role))
+	})
+
+	t.Run("project level", func(t *testing.T) {
+		role := &harborRoleEntry{RobotLevel: robotLevelProject, Project: "public", Permissions: permissions}
+
+		merged := robotPermissions(role)
+		require.Len(t, merged, 1)
+		require.Equal(t, "public", merged[0].Namespace)
+		require.Equal(t, permissionKindProject, merged[0].Kind)
+		require.Len(t, merged[0].Access, 2)
+	})
+}
+
+func TestRobotDurationDays(t *testing.T) {
+	require.Equal(t, int64(1), robotDurationDays(15*time.Minute))
+	require.Equal(t, int64(1), robotDurationDays(8*time.Hour))
+	require.Equal(t, int64(2), robotDurationDays(24*time.Hour))
+	require.Equal(t, int64(4), robotDurationDays(72*time.Hour+time.Minute))
+}
+
+func TestRoleMaxTTL(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	require.Equal(t, time.Hour, b.roleMaxTTL(&harborRoleEntry{MaxTTL: time.Hour}))
+	require.Equal(t, b.System().MaxLeaseTTL(), b.roleMaxTTL(&harborRoleEntry{}))
+}
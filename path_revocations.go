@@ -0,0 +1,51 @@
+package harbor
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRevocationsPendingHelpSynopsis    = `List the robot accounts whose deletion from Harbor is pending.`
+	pathRevocationsPendingHelpDescription = `
+When a robot account can't be deleted from Harbor as its lease is revoked, the lease
+is revoked anyway and the deletion is queued. The backend retries it, with a backoff,
+until Harbor deletes the robot account or reports it gone. This path lists the queue.
+`
+)
+
+// pathRevocationsPending extends the Vault API with a `/revocations/pending`
+// endpoint for the backend.
+func pathRevocationsPending(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revocations/pending$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRevocationsPendingRead,
+			},
+		},
+		HelpSynopsis:    pathRevocationsPendingHelpSynopsis,
+		HelpDescription: pathRevocationsPendingHelpDescription,
+	}
+}
+
+// pathRevocationsPendingRead lists the pending revocations.
+func (b *harborBackend) pathRevocationsPendingRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	revocations, err := listPendingRevocations(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]map[string]interface{}, 0, len(revocations))
+	for _, revocation := range revocations {
+		pending = append(pending, revocation.toResponseData())
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"pending": pending,
+		},
+	}, nil
+}
@@ -0,0 +1,81 @@
+package harbor
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRobotsHelpSynopsis    = `Read the live robot accounts issued by the backend.`
+	pathRobotsHelpDescription = `
+This path allows you to read the record of a robot account issued by the backend,
+by the name the backend gave to it: its ID, name, connection, role, the entity it
+was issued to, when it was created and when its lease is set to expire.
+The record is removed when the lease of the robot account is revoked.
+`
+
+	pathRobotsListHelpSynopsis    = `List the live robot accounts issued by the backend.`
+	pathRobotsListHelpDescription = `Robot accounts will be listed by the name the backend gave to them.`
+)
+
+// pathRobots extends the Vault API with a `/robots`
+// endpoint for the backend.
+func pathRobots(b *harborBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "robots/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the robot account, without the robot prefix",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathRobotsRead,
+				},
+			},
+			HelpSynopsis:    pathRobotsHelpSynopsis,
+			HelpDescription: pathRobotsHelpDescription,
+		},
+		{
+			Pattern: "robots/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRobotsList,
+				},
+			},
+			HelpSynopsis:    pathRobotsListHelpSynopsis,
+			HelpDescription: pathRobotsListHelpDescription,
+		},
+	}
+}
+
+// pathRobotsList makes a request to Vault storage to retrieve a list of the live robot accounts
+func (b *harborBackend) pathRobotsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, robotStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathRobotsRead makes a request to Vault storage to read the record of a robot account
+func (b *harborBackend) pathRobotsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	record, err := getRobotRecord(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: record.toResponseData(),
+	}, nil
+}
@@ -0,0 +1,88 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRobots uses a fake Harbor API to check the inventory of
+// the robot accounts, and their revocation by recorded ID.
+func TestRobots(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	created := time.Now().Truncate(time.Second)
+
+	require.NoError(t, putRobotRecord(ctx, s, "vault.test.1", &harborRobotRecord{
+		ID:        7,
+		Name:      robotName,
+		Role:      roleName,
+		EntityID:  "entity-1",
+		CreatedAt: created,
+		ExpiresAt: created.Add(time.Hour),
+	}))
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "robots/",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"vault.test.1"}, resp.Data["keys"])
+	})
+
+	t.Run("read", func(t *testing.T) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "robots/vault.test.1",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"robot_account_id":   int64(7),
+			"robot_account_name": robotName,
+			"connection":         "",
+			"role":               roleName,
+			"entity_id":          "entity-1",
+			"created_at":         created.Format(time.RFC3339),
+			"expires_at":         created.Add(time.Hour).Format(time.RFC3339),
+		}, resp.Data)
+	})
+
+	t.Run("revoke by recorded ID", func(t *testing.T) {
+		// the lease predates the robot account ID in its internal data
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret: &logical.Secret{InternalData: map[string]interface{}{
+				"secret_type":        harborRobotAccountType,
+				"role":               roleName,
+				"robot_account_name": "vault.test.1",
+			}},
+			Storage: s,
+		})
+		require.NoError(t, err)
+		require.True(t, harborServer.RobotDeleted)
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "robots/vault.test.1",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+}
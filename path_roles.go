@@ -2,12 +2,10 @@ package harbor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/jsonutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
 )
@@ -16,33 +14,111 @@ const (
 	pathRoleHelpSynopsis    = `Manages the Vault role for generating Harbor robot account tokens.`
 	pathRoleHelpDescription = `
 This path allows you to read and write roles used to generate Harbor robot account tokens.
-You can configure a role to manage a robot account's token by setting the permissions field.
+You can configure a role to manage a robot account's token by setting the permissions field,
+a list of objects with the kind, namespace and access of each permission. The permissions
+are validated against the resources and actions Harbor grants to robot accounts.
 `
 
 	pathRoleListHelpSynopsis    = `List the existing roles in Harbor backend`
 	pathRoleListHelpDescription = `Roles will be listed by the role name.`
+
+	permissionKindSystem  = "system"
+	permissionKindProject = "project"
+
+	robotLevelSystem  = "system"
+	robotLevelProject = "project"
+
+	revocationModeDelete                 = "delete"
+	revocationModeDisable                = "disable"
+	revocationModeDisableThenDeleteAfter = "disable_then_delete_after"
+
+	// defaultRevocationDeleteAfter is how long the robot accounts are
+	// kept disabled, with the disable_then_delete_after revocation mode
+	defaultRevocationDeleteAfter = 7 * 24 * time.Hour
+
+	// allProjectsNamespace is the namespace of the
+	// project permissions covering all projects
+	allProjectsNamespace = "*"
 )
 
 // harborRoleEntry defines the data required
 // for a Vault role to access and call the Harbor
 // token endpoints
 type harborRoleEntry struct {
-	TTL         time.Duration                  `json:"ttl"`
-	MaxTTL      time.Duration                  `json:"max_ttl"`
-	Permissions []*harborModel.RobotPermission `json:"permissions"`
+	Connection   string                         `json:"connection"`
+	RobotLevel   string                         `json:"robot_level"`
+	Project      string                         `json:"project"`
+	NameTemplate string                         `json:"name_template"`
+	TTL          time.Duration                  `json:"ttl"`
+	MaxTTL       time.Duration                  `json:"max_ttl"`
+	Permissions  []*harborModel.RobotPermission `json:"permissions"`
+
+	// CoverAllProjects is nil for the roles written before it was added
+	CoverAllProjects *bool `json:"cover_all_projects"`
+
+	RevocationMode        string        `json:"revocation_mode"`
+	RevocationDeleteAfter time.Duration `json:"revocation_delete_after"`
+
+	OutputFormat string `json:"output_format"`
+	RegistryHost string `json:"registry_host"`
 }
 
 // toResponseData returns response data for a role
 func (r *harborRoleEntry) toResponseData() map[string]interface{} {
-	p, _ := json.Marshal(r.Permissions)
 	respData := map[string]interface{}{
-		"ttl":         r.TTL.Seconds(),
-		"max_ttl":     r.MaxTTL.Seconds(),
-		"permissions": string(p),
+		"connection":         r.Connection,
+		"robot_level":        r.robotLevel(),
+		"project":            r.Project,
+		"name_template":      r.NameTemplate,
+		"cover_all_projects": r.coverAllProjects(),
+		"ttl":                r.TTL.Seconds(),
+		"max_ttl":            r.MaxTTL.Seconds(),
+		"permissions":        permissionsResponseData(r.Permissions),
+
+		"revocation_mode":         r.revocationMode(),
+		"revocation_delete_after": r.RevocationDeleteAfter.Seconds(),
+
+		"output_format": r.outputFormat(),
+		"registry_host": r.RegistryHost,
 	}
 	return respData
 }
 
+// coverAllProjects tells whether the "*" namespace is sent to Harbor as is,
+// rather than expanded to the current projects.
+func (r *harborRoleEntry) coverAllProjects() bool {
+	return r.CoverAllProjects == nil || *r.CoverAllProjects
+}
+
+// robotLevel returns the level of the robot accounts of the role,
+// roles written before project robot accounts were supported are system level.
+func (r *harborRoleEntry) robotLevel() string {
+	if r.RobotLevel == "" {
+		return robotLevelSystem
+	}
+
+	return r.RobotLevel
+}
+
+// revocationMode returns what is done with the robot accounts of the role when
+// their lease is revoked, roles written before it was added delete them.
+func (r *harborRoleEntry) revocationMode() string {
+	if r.RevocationMode == "" {
+		return revocationModeDelete
+	}
+
+	return r.RevocationMode
+}
+
+// outputFormat returns the default output format of the credentials of the role.
+func (r *harborRoleEntry) outputFormat() string {
+	if r.OutputFormat == "" {
+		return outputFormatDefault
+	}
+
+	return r.OutputFormat
+}
+
 // pathRoles extends the Vault API with a `/roles`
 // endpoint for the backend.
 func pathRoles(b *harborBackend) []*framework.Path {
@@ -56,10 +132,59 @@ func pathRoles(b *harborBackend) []*framework.Path {
 					Required:    true,
 				},
 				"permissions": {
-					Type:        framework.TypeString,
-					Description: "The permissions for the Harbor robot account",
+					Type:        framework.TypeSlice,
+					Description: "The permissions for the Harbor robot account, a list of objects with kind, namespace and access",
 					Required:    true,
 				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the Harbor connection to create robot accounts on, it must be configured. If not set, will use the default connection.",
+				},
+				"robot_level": {
+					Type:          framework.TypeString,
+					Description:   "Level of the robot accounts, system or project. Project robot accounts are created in the project set by project. Defaults to system.",
+					AllowedValues: []interface{}{robotLevelSystem, robotLevelProject},
+				},
+				"project": {
+					Type:        framework.TypeString,
+					Description: "Name of the project the robot accounts are created in, required when robot_level is project",
+				},
+				"name_template": {
+					Type: framework.TypeString,
+					Description: "Template of the robot account names, using the Vault username template language " +
+						"with .RoleName, .DisplayName and .EntityID. If not set, will use the name_template of the connection.",
+				},
+				"cover_all_projects": {
+					Type: framework.TypeBool,
+					Description: "Grant the permissions of namespace \"*\" on all projects, including the future ones, " +
+						"when Harbor supports it. Otherwise, the \"*\" namespace is expanded to the current projects. Defaults to true.",
+					Default: true,
+				},
+				"revocation_mode": {
+					Type: framework.TypeString,
+					Description: "What to do with the robot accounts when their lease is revoked: delete them, disable them, " +
+						"or disable them then delete them after revocation_delete_after. Defaults to delete.",
+					AllowedValues: []interface{}{revocationModeDelete, revocationModeDisable, revocationModeDisableThenDeleteAfter},
+				},
+				"revocation_delete_after": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the revoked robot accounts are kept disabled before their deletion, with the disable_then_delete_after revocation mode. Defaults to 7 days.",
+				},
+				"output_format": {
+					Type: framework.TypeString,
+					Description: "Default output format of the credentials: default, or dockerconfigjson to also return " +
+						"a docker config JSON document authenticating on the registry. Defaults to default.",
+					AllowedValues: []interface{}{outputFormatDefault, outputFormatDockerConfigJSON},
+				},
+				"registry_host": {
+					Type:        framework.TypeString,
+					Description: "Registry host of the docker config JSON documents, with an optional port. If not set, will use the host of the connection URL.",
+				},
+				"propagate": {
+					Type: framework.TypeBool,
+					Description: "Update the permissions of the live robot accounts of the role to the new ones, on role update. " +
+						"The robot accounts whose scope the role doesn't grant anymore are revoked.",
+				},
 				"ttl": {
 					Type:        framework.TypeDurationSecond,
 					Description: "Default lease for generated credentials. If not set or set to 0, will use system default.",
@@ -101,13 +226,13 @@ func pathRoles(b *harborBackend) []*framework.Path {
 }
 
 // pathRoleExistenceCheck verifies if the role exists.
-func (b *harborBackend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
-	out, err := req.Storage.Get(ctx, req.Path)
+func (b *harborBackend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	entry, err := b.getRole(ctx, req.Storage, d.Get("name").(string))
 	if err != nil {
 		return false, fmt.Errorf("existence check failed: %w", err)
 	}
 
-	return out != nil, nil
+	return entry != nil, nil
 }
 
 // pathRolesList makes a request to Vault storage to retrieve a list of roles for the backend
@@ -148,6 +273,9 @@ func (b *harborBackend) pathRolesWrite(ctx context.Context, req *logical.Request
 		return nil, err
 	}
 
+	// the robot accounts of the role exist whatever the operation is
+	roleExisted := roleEntry != nil
+
 	if roleEntry == nil {
 		roleEntry = &harborRoleEntry{}
 	}
@@ -155,17 +283,95 @@ func (b *harborBackend) pathRolesWrite(ctx context.Context, req *logical.Request
 	createOperation := (req.Operation == logical.CreateOperation)
 
 	if permissions, ok := d.GetOk("permissions"); ok {
-		parsedPermissions := make([]*harborModel.RobotPermission, 0) // non-nil to avoid a "missing permissions" error later
-
-		err := jsonutil.DecodeJSON([]byte(permissions.(string)), &parsedPermissions)
+		parsedPermissions, err := parsePermissions(permissions.([]interface{}))
 		if err != nil {
-			return logical.ErrorResponse("error parsing Permissions '%s': %s", permissions.(string), err.Error()), nil
+			return logical.ErrorResponse("error parsing permissions: %s", err.Error()), nil
+		}
+
+		if err := validatePermissions(parsedPermissions); err != nil {
+			return logical.ErrorResponse("invalid permissions: %s", err.Error()), nil
 		}
 		roleEntry.Permissions = parsedPermissions
 	} else if !ok && createOperation {
 		return nil, fmt.Errorf("missing permissions in role")
 	}
 
+	if connection, ok := d.GetOk("connection"); ok {
+		if resp, err := checkConnection(ctx, req.Storage, connection.(string)); resp != nil || err != nil {
+			return resp, err
+		}
+		roleEntry.Connection = connection.(string)
+	}
+
+	if robotLevel, ok := d.GetOk("robot_level"); ok {
+		roleEntry.RobotLevel = robotLevel.(string)
+	}
+
+	if project, ok := d.GetOk("project"); ok {
+		roleEntry.Project = project.(string)
+	}
+
+	if nameTemplate, ok := d.GetOk("name_template"); ok {
+		if nameTemplate.(string) != "" {
+			if err := checkNameTemplate(nameTemplate.(string)); err != nil {
+				return logical.ErrorResponse("invalid name_template: %s", err.Error()), nil
+			}
+		}
+		roleEntry.NameTemplate = nameTemplate.(string)
+	}
+
+	if coverAllProjects, ok := d.GetOk("cover_all_projects"); ok {
+		roleEntry.CoverAllProjects = new(bool)
+		*roleEntry.CoverAllProjects = coverAllProjects.(bool)
+	}
+
+	if resp := validateRobotLevel(roleEntry); resp != nil {
+		return resp, nil
+	}
+
+	caps, err := b.knownCapabilities(ctx, req.Storage, roleEntry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if caps != nil {
+		if err := caps.checkPermissionKinds(robotPermissions(roleEntry)); err != nil {
+			return logical.ErrorResponse("the role can't be used on its connection: %s", err.Error()), nil
+		}
+	}
+
+	if outputFormat, ok := d.GetOk("output_format"); ok {
+		roleEntry.OutputFormat = outputFormat.(string)
+	}
+
+	if registryHost, ok := d.GetOk("registry_host"); ok {
+		if registryHost.(string) != "" {
+			if err := validateRegistryHost(registryHost.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		roleEntry.RegistryHost = registryHost.(string)
+	}
+
+	if revocationMode, ok := d.GetOk("revocation_mode"); ok {
+		roleEntry.RevocationMode = revocationMode.(string)
+	}
+
+	if deleteAfter, ok := d.GetOk("revocation_delete_after"); ok {
+		roleEntry.RevocationDeleteAfter = time.Duration(deleteAfter.(int)) * time.Second
+	} else if roleEntry.revocationMode() != revocationModeDisableThenDeleteAfter {
+		roleEntry.RevocationDeleteAfter = 0
+	}
+
+	switch {
+	case roleEntry.RevocationDeleteAfter < 0:
+		return logical.ErrorResponse("revocation_delete_after cannot be negative"), nil
+	case roleEntry.RevocationDeleteAfter > 0 && roleEntry.revocationMode() != revocationModeDisableThenDeleteAfter:
+		return logical.ErrorResponse("revocation_delete_after is only used when revocation_mode is %s", revocationModeDisableThenDeleteAfter), nil
+	case roleEntry.RevocationDeleteAfter == 0 && roleEntry.revocationMode() == revocationModeDisableThenDeleteAfter:
+		roleEntry.RevocationDeleteAfter = defaultRevocationDeleteAfter
+	}
+
 	if ttlRaw, ok := d.GetOk("ttl"); ok {
 		roleEntry.TTL = time.Duration(ttlRaw.(int)) * time.Second
 	} else if createOperation {
@@ -186,7 +392,51 @@ func (b *harborBackend) pathRolesWrite(ctx context.Context, req *logical.Request
 		return nil, err
 	}
 
-	return nil, nil
+	// a new role has no robot accounts yet
+	if !d.Get("propagate").(bool) || !roleExisted {
+		return nil, nil
+	}
+
+	results, failed, err := b.propagateRole(ctx, req.Storage, name.(string), roleEntry)
+	if err != nil {
+		return nil, fmt.Errorf("error propagating role permissions: %w", err)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"propagation": results,
+		},
+	}
+	if failed > 0 {
+		resp.AddWarning(fmt.Sprintf("the permissions of %d of %d robot accounts could not be updated, revoke them to apply the role", failed, len(results)))
+	}
+
+	return resp, nil
+}
+
+// validateRobotLevel checks the project and the permissions of the role match its robot level.
+// Project robot accounts only get permissions on their own project.
+func validateRobotLevel(roleEntry *harborRoleEntry) *logical.Response {
+	switch roleEntry.robotLevel() {
+	case robotLevelProject:
+		if roleEntry.Project == "" {
+			return logical.ErrorResponse("missing project, required when robot_level is %s", robotLevelProject)
+		}
+
+		for i, permission := range roleEntry.Permissions {
+			if permission.Kind != permissionKindProject || permission.Namespace != roleEntry.Project {
+				return logical.ErrorResponse(
+					"invalid permission %d: robot accounts of level %s only get permissions of kind %s on project %q",
+					i+1, robotLevelProject, permissionKindProject, roleEntry.Project)
+			}
+		}
+	default:
+		if roleEntry.Project != "" {
+			return logical.ErrorResponse("project is only used when robot_level is %s", robotLevelProject)
+		}
+	}
+
+	return nil
 }
 
 // pathRolesDelete makes a request to Vault storage to delete a role
@@ -0,0 +1,114 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRoleRevokeAllHelpSynopsis    = `Revoke all the live robot accounts issued from a role.`
+	pathRoleRevokeAllHelpDescription = `
+This path deletes, or disables following the revocation_mode of the role, all the
+robot accounts issued from the role which are live, so that decommissioning a role
+takes effect right away. It can be used after the role is deleted, the robot accounts
+are then deleted. The revocations failing in Harbor are queued and retried.
+Their leases are left to expire, or to be revoked, which leaves the robot accounts as
+revoke-all left them, and can't be renewed anymore. The leases issued before the robot
+account IDs were recorded are revoked again when they expire or are revoked.
+`
+)
+
+// pathRoleRevokeAll extends the Vault API with a `/roles/<name>/revoke-all`
+// endpoint for the backend.
+func pathRoleRevokeAll(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/revoke-all$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathRoleRevokeAllUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathRoleRevokeAllHelpSynopsis,
+		HelpDescription: pathRoleRevokeAllHelpDescription,
+	}
+}
+
+// pathRoleRevokeAllUpdate revokes the live robot accounts of a role,
+// and reports how many were revoked.
+func (b *harborBackend) pathRoleRevokeAllUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the robot accounts of a deleted role are deleted
+	mode := revocationModeDelete
+	if role != nil {
+		mode = role.revocationMode()
+	}
+
+	names, err := req.Storage.List(ctx, robotStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, queued := 0, 0
+
+	for _, robotName := range names {
+		record, err := getRobotRecord(ctx, req.Storage, robotName)
+		if err != nil {
+			return nil, err
+		}
+
+		if record == nil || record.Role != name {
+			continue
+		}
+
+		revocation := &pendingRevocation{
+			ID:         record.ID,
+			Name:       robotName,
+			Connection: record.Connection,
+			Mode:       mode,
+		}
+		if role != nil {
+			revocation.DeleteAfter = role.RevocationDeleteAfter
+		}
+
+		failed, err := b.revokeRobotAccount(ctx, req.Storage, revocation)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := deleteRobotRecord(ctx, req.Storage, robotName); err != nil {
+			return nil, fmt.Errorf("error deleting robot account record: %w", err)
+		}
+
+		if failed {
+			queued++
+		} else {
+			revoked++
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revocation_mode": mode,
+			"revoked":         revoked,
+			"queued":          queued,
+		},
+	}, nil
+}
@@ -0,0 +1,112 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRoleRevokeAll uses a fake Harbor API to check that the
+// live robot accounts of a role are revoked at once.
+func TestRoleRevokeAll(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(50), "name": "robot$vault.test-role.1"},
+		{"id": int64(51), "name": "robot$vault.test-role.2"},
+		{"id": int64(52), "name": "robot$vault.deleted-role.1"},
+	}
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	_, err = testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions":     testPermissions,
+		"revocation_mode": "disable",
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for name, record := range map[string]*harborRobotRecord{
+		"vault.test-role.1":    {ID: 50, Role: roleName},
+		"vault.test-role.2":    {ID: 51, Role: roleName},
+		"vault.deleted-role.1": {ID: 52, Role: "deleted-role"},
+	} {
+		record.ExpiresAt = time.Now().Add(time.Hour)
+		require.NoError(t, putRobotRecord(ctx, s, name, record))
+	}
+
+	revokeAll := func(role string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role + "/revoke-all",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("role", func(t *testing.T) {
+		resp := revokeAll(roleName)
+		require.Equal(t, 2, resp.Data["revoked"])
+		require.Equal(t, 0, resp.Data["queued"])
+		require.Equal(t, []int64{50, 51}, harborServer.DisabledRobots)
+		require.Empty(t, harborServer.DeletedRobots)
+
+		names, err := s.List(ctx, robotStoragePrefix)
+		require.NoError(t, err)
+		require.Equal(t, []string{"vault.deleted-role.1"}, names)
+	})
+
+	t.Run("leases of the revoked robot accounts", func(t *testing.T) {
+		description := harborServer.Robots[0]["description"]
+
+		lease := &logical.Secret{
+			LeaseID: "harbor/creds/test-role/1",
+			InternalData: map[string]interface{}{
+				"secret_type":        harborRobotAccountType,
+				"role":               roleName,
+				"robot_account_id":   int64(50),
+				"robot_account_name": "vault.test-role.1",
+				"revocation_mode":    revocationModeDisable,
+			},
+		}
+		lease.IssueTime = time.Now()
+
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RenewOperation,
+			Secret:    lease,
+			Storage:   s,
+		})
+		require.ErrorContains(t, err, "was revoked")
+
+		_, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    lease,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int64{50, 51}, harborServer.DisabledRobots)
+		require.Equal(t, description, harborServer.Robots[0]["description"])
+	})
+
+	t.Run("deleted role", func(t *testing.T) {
+		resp := revokeAll("deleted-role")
+		require.Equal(t, "delete", resp.Data["revocation_mode"])
+		require.Equal(t, 1, resp.Data["revoked"])
+		require.Equal(t, []int64{52}, harborServer.DeletedRobots)
+	})
+
+	t.Run("nothing left", func(t *testing.T) {
+		resp := revokeAll(roleName)
+		require.Equal(t, 0, resp.Data["revoked"])
+	})
+}
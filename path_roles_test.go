@@ -2,14 +2,11 @@ package harbor
 
 import (
 	"context"
-	"encoding/json"
 	"strconv"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
-
-	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
 )
 
 const (
@@ -67,6 +64,52 @@ func TestUserRole(t *testing.T) {
 		require.Nil(t, resp)
 	})
 
+	t.Run("Create User Role-named connection", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      configStoragePath + "/eu",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"username":          username,
+				"password":          password,
+				"url":               url,
+				"verify_connection": false,
+			},
+		})
+		require.NoError(t, err)
+
+		resp, err := testTokenRoleCreate(t, b, s, roleName+"-eu", map[string]interface{}{
+			"permissions": testPermissions,
+			"connection":  "eu",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "roles/" + roleName + "-eu",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "eu", resp.Data["connection"])
+	})
+
+	t.Run("Create User Role-unknown connection", func(t *testing.T) {
+		for _, connection := range []string{"us", "rotate-root"} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "roles/" + roleName + "-" + connection,
+				Storage:   s,
+				Data: map[string]interface{}{
+					"permissions": testPermissions,
+					"connection":  connection,
+				},
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+		}
+	})
+
 	t.Run("Create User Role-fail", func(t *testing.T) {
 		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
 			"ttl":     testTTL,
@@ -98,14 +141,68 @@ func TestUserRole(t *testing.T) {
 
 	t.Run("Re-read User Role", func(t *testing.T) {
 		resp, err := testTokenRoleRead(t, b, s)
-		var permissions []harborModel.RobotPermission
-		_ = json.Unmarshal([]byte(testPermissions), &permissions)
-		expectedPermissions, _ := json.Marshal(permissions)
+		expectedPermissions := []map[string]interface{}{
+			{
+				"kind":      "project",
+				"namespace": "public",
+				"access": []map[string]interface{}{
+					{"resource": "repository", "action": "pull"},
+				},
+			},
+		}
 
 		require.Nil(t, err)
 		require.Nil(t, resp.Error())
 		require.NotNil(t, resp)
-		require.Equal(t, string(expectedPermissions), resp.Data["permissions"])
+		require.Equal(t, expectedPermissions, resp.Data["permissions"])
+	})
+
+	t.Run("Update User Role-structured permissions", func(t *testing.T) {
+		resp, err := testTokenRoleUpdate(t, b, s, map[string]interface{}{
+			"permissions": []interface{}{
+				map[string]interface{}{
+					"kind":      "project",
+					"namespace": "library",
+					"access": []interface{}{
+						map[string]interface{}{"resource": "repository", "action": "push"},
+						map[string]interface{}{"resource": "artifact", "action": "*", "effect": "allow"},
+					},
+				},
+			},
+		})
+
+		require.Nil(t, err)
+		require.Nil(t, resp.Error())
+		require.Nil(t, resp)
+
+		resp, err = testTokenRoleRead(t, b, s)
+		require.Nil(t, err)
+		require.Equal(t, []map[string]interface{}{
+			{
+				"kind":      "project",
+				"namespace": "library",
+				"access": []map[string]interface{}{
+					{"resource": "repository", "action": "push"},
+					{"resource": "artifact", "action": "*", "effect": "allow"},
+				},
+			},
+		}, resp.Data["permissions"])
+	})
+
+	t.Run("Update User Role-invalid permissions", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + roleName,
+			Data: map[string]interface{}{
+				"permissions": `[{"kind":"project","namespace":"public","access":[{"resource":"repositry","action":"pull"}]}]`,
+			},
+			Storage: s,
+		})
+
+		require.Nil(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `invalid resource "repositry"`)
+		require.Contains(t, resp.Error().Error(), "repository")
 	})
 
 	t.Run("Delete User Role", func(t *testing.T) {
@@ -115,6 +212,108 @@ func TestUserRole(t *testing.T) {
 	})
 }
 
+// TestProjectRole checks the project robot accounts settings of a role.
+func TestProjectRole(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	t.Run("Create Project Role-missing project", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+			"robot_level": "project",
+			"permissions": testPermissions,
+		})
+
+		require.Nil(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Project Role-permission on another project", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+			"robot_level": "project",
+			"project":     "library",
+			"permissions": testPermissions,
+		})
+
+		require.Nil(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `on project "library"`)
+	})
+
+	t.Run("Create Project Role-pass", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+			"robot_level": "project",
+			"project":     "public",
+			"permissions": testPermissions,
+		})
+
+		require.Nil(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testTokenRoleRead(t, b, s)
+		require.Nil(t, err)
+		require.Equal(t, "project", resp.Data["robot_level"])
+		require.Equal(t, "public", resp.Data["project"])
+	})
+
+	t.Run("Update Project Role-project on system level", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + roleName,
+			Data:      map[string]interface{}{"robot_level": "system"},
+			Storage:   s,
+		})
+
+		require.Nil(t, err)
+		require.True(t, resp.IsError())
+	})
+}
+
+func TestRoleRevocationMode(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	t.Run("Create Role-delete after without delay mode", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+			"revocation_mode":         "disable",
+			"revocation_delete_after": "24h",
+			"permissions":             testPermissions,
+		})
+
+		require.Nil(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Role-default delay", func(t *testing.T) {
+		resp, err := testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+			"revocation_mode": "disable_then_delete_after",
+			"permissions":     testPermissions,
+		})
+
+		require.Nil(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testTokenRoleRead(t, b, s)
+		require.Nil(t, err)
+		require.Equal(t, "disable_then_delete_after", resp.Data["revocation_mode"])
+		require.Equal(t, float64(7*24*3600), resp.Data["revocation_delete_after"])
+	})
+
+	t.Run("Update Role-back to delete", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + roleName,
+			Data:      map[string]interface{}{"revocation_mode": "delete"},
+			Storage:   s,
+		})
+
+		require.Nil(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testTokenRoleRead(t, b, s)
+		require.Nil(t, err)
+		require.Equal(t, "delete", resp.Data["revocation_mode"])
+		require.Equal(t, float64(0), resp.Data["revocation_delete_after"])
+	})
+}
+
 // Utility function to create a role while, returning any response (including errors)
 func testTokenRoleCreate(
 	t *testing.T,
@@ -0,0 +1,74 @@
+package harbor
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	//nolint:gosec
+	pathStaticCredsHelpSyn  = `Read the current secret of the Harbor robot account of a static role.`
+	pathStaticCredsHelpDesc = `This path returns the robot account of a static role
+with its current secret, and when the secret is rotated next.`
+)
+
+// pathStaticCreds extends the Vault API with a `/static-creds`
+// endpoint for a static role.
+func pathStaticCreds(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-creds/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the static role",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStaticCredsRead,
+			},
+		},
+		HelpSynopsis:    pathStaticCredsHelpSyn,
+		HelpDescription: pathStaticCredsHelpDesc,
+	}
+}
+
+// pathStaticCredsRead returns the robot account of a static role and its current secret.
+func (b *harborBackend) pathStaticCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	roleEntry, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving static role: %w", err)
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("static role %q not found", name), nil
+	}
+
+	robotToken := fmt.Sprintf("%s:%s", roleEntry.RobotName, roleEntry.Secret)
+
+	// the secret may be overdue until the periodic function rotates it
+	ttl := time.Until(roleEntry.nextRotation())
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"robot_account_id":         roleEntry.RobotID,
+			"robot_account_name":       roleEntry.RobotName,
+			"robot_account_secret":     roleEntry.Secret,
+			"robot_account_auth_token": base64.StdEncoding.EncodeToString([]byte(robotToken)),
+			"last_rotated":             roleEntry.LastRotated.Format(time.RFC3339),
+			"rotation_period":          int64(roleEntry.RotationPeriod.Seconds()),
+			"ttl":                      int64(ttl.Seconds()),
+		},
+	}, nil
+}
@@ -0,0 +1,314 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathStaticRoleHelpSynopsis    = `Manages the Vault static roles bound to existing Harbor robot accounts.`
+	pathStaticRoleHelpDescription = `
+This path allows you to read and write static roles. A static role is bound to an
+existing Harbor robot account, by name or by ID, whose secret is rotated by the
+backend every rotation_period. The robot account itself is never created nor
+deleted by the backend. Its secret is rotated when the static role is created.
+`
+
+	pathStaticRoleListHelpSynopsis    = `List the existing static roles in Harbor backend`
+	pathStaticRoleListHelpDescription = `Static roles will be listed by the role name.`
+
+	staticRoleStoragePrefix = "static-role/"
+
+	// minRotationPeriod is the shortest rotation period of the static roles,
+	// the secrets are rotated by the periodic function, which runs every minute.
+	minRotationPeriod = time.Minute
+)
+
+// harborStaticRoleEntry defines a Vault static role
+// bound to an existing Harbor robot account.
+type harborStaticRoleEntry struct {
+	Connection     string        `json:"connection"`
+	RobotID        int64         `json:"robot_id"`
+	RobotName      string        `json:"robot_name"`
+	RotationPeriod time.Duration `json:"rotation_period"`
+	Secret         string        `json:"secret"`
+	LastRotated    time.Time     `json:"last_rotated"`
+}
+
+// toResponseData returns response data for a static role
+func (r *harborStaticRoleEntry) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"connection":      r.Connection,
+		"robot_id":        r.RobotID,
+		"robot_name":      r.RobotName,
+		"rotation_period": int64(r.RotationPeriod.Seconds()),
+		"last_rotated":    r.LastRotated.Format(time.RFC3339),
+	}
+}
+
+// nextRotation returns when the secret of the static role is rotated next.
+func (r *harborStaticRoleEntry) nextRotation() time.Time {
+	return r.LastRotated.Add(r.RotationPeriod)
+}
+
+// pathStaticRoles extends the Vault API with a `/static-roles`
+// endpoint for the backend.
+func pathStaticRoles(b *harborBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "static-roles/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the Harbor connection of the robot account. If not set, will use the default connection.",
+				},
+				"robot_name": {
+					Type:        framework.TypeString,
+					Description: "Name of the existing Harbor robot account, with or without the robot prefix. Either robot_name or robot_id must be set.",
+				},
+				"robot_id": {
+					Type:        framework.TypeInt,
+					Description: "ID of the existing Harbor robot account. Either robot_name or robot_id must be set.",
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Period of the robot account secret rotation, at least one minute",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback:                    b.pathStaticRolesWrite,
+					ForwardPerformanceStandby:   true,
+					ForwardPerformanceSecondary: true,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathStaticRolesWrite,
+					ForwardPerformanceStandby:   true,
+					ForwardPerformanceSecondary: true,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesDelete,
+				},
+			},
+			HelpSynopsis:    pathStaticRoleHelpSynopsis,
+			HelpDescription: pathStaticRoleHelpDescription,
+			ExistenceCheck:  b.pathStaticRoleExistenceCheck,
+		},
+		{
+			Pattern: "static-roles/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesList,
+				},
+			},
+			HelpSynopsis:    pathStaticRoleListHelpSynopsis,
+			HelpDescription: pathStaticRoleListHelpDescription,
+		},
+	}
+}
+
+// pathStaticRoleExistenceCheck verifies if the static role exists.
+func (b *harborBackend) pathStaticRoleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	entry, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %w", err)
+	}
+
+	return entry != nil, nil
+}
+
+// pathStaticRolesList makes a request to Vault storage to retrieve a list of static roles for the backend
+func (b *harborBackend) pathStaticRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathStaticRolesRead makes a request to Vault storage to read a static role and return response data
+func (b *harborBackend) pathStaticRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entry, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: entry.toResponseData(),
+	}, nil
+}
+
+// pathStaticRolesWrite creates a static role, binding it to an existing robot account
+// and rotating its secret, or updates the rotation period of a static role.
+func (b *harborBackend) pathStaticRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	roleEntry, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	createOperation := roleEntry == nil
+
+	if !createOperation {
+		// the robot account of a static role can't be changed,
+		// its secret is only known to the backend.
+		for _, field := range []string{"connection", "robot_name", "robot_id"} {
+			if _, ok := d.GetOk(field); ok {
+				return logical.ErrorResponse("%s cannot be changed, delete and create the static role again", field), nil
+			}
+		}
+	} else {
+		roleEntry = &harborStaticRoleEntry{
+			Connection: d.Get("connection").(string),
+			RobotName:  d.Get("robot_name").(string),
+			RobotID:    int64(d.Get("robot_id").(int)),
+		}
+
+		if roleEntry.RobotName == "" && roleEntry.RobotID == 0 {
+			return logical.ErrorResponse("missing robot_name or robot_id"), nil
+		}
+	}
+
+	if rotationPeriod, ok := d.GetOk("rotation_period"); ok {
+		roleEntry.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+	} else if createOperation {
+		return logical.ErrorResponse("missing rotation_period"), nil
+	}
+
+	if roleEntry.RotationPeriod < minRotationPeriod {
+		return logical.ErrorResponse("rotation_period must be at least %s", minRotationPeriod), nil
+	}
+
+	if createOperation {
+		client, err := b.getClient(ctx, req.Storage, roleEntry.Connection)
+		if err != nil {
+			return nil, err
+		}
+
+		robot, err := findRobot(ctx, client, roleEntry.RobotID, roleEntry.RobotName)
+		if err != nil {
+			return nil, err
+		}
+
+		if robot == nil {
+			return logical.ErrorResponse("robot account %s not found in Harbor", robotDisplayName(roleEntry.RobotID, roleEntry.RobotName)), nil
+		}
+
+		roleEntry.RobotID = robot.ID
+		roleEntry.RobotName = robot.Name
+
+		if err := rotateStaticRole(ctx, client, roleEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setStaticRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// pathStaticRolesDelete makes a request to Vault storage to delete a static role,
+// the robot account is left in Harbor.
+func (b *harborBackend) pathStaticRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	err := req.Storage.Delete(ctx, staticRoleStoragePrefix+d.Get("name").(string))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting harbor static role: %w", err)
+	}
+
+	return nil, nil
+}
+
+// findRobot looks a robot account up by its ID when it is known,
+// or by its name. It returns nil if the robot account doesn't exist.
+func findRobot(ctx context.Context, c *harborClient, robotID int64, robotName string) (*harborRobot, error) {
+	if robotID == 0 {
+		robot, err := c.getRobotByName(ctx, robotName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving Harbor robot account: %w", err)
+		}
+		return robot, nil
+	}
+
+	robot, err := c.getRobot(ctx, robotID)
+	if isHarborNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving Harbor robot account: %w", err)
+	}
+
+	return robot, nil
+}
+
+// robotDisplayName returns how a robot account is shown in messages
+func robotDisplayName(robotID int64, robotName string) string {
+	if robotName != "" {
+		return fmt.Sprintf("%q", robotName)
+	}
+
+	return fmt.Sprintf("%d", robotID)
+}
+
+// setStaticRole adds the static role to the Vault storage API
+func setStaticRole(ctx context.Context, s logical.Storage, name string, roleEntry *harborStaticRoleEntry) error {
+	entry, err := logical.StorageEntryJSON(staticRoleStoragePrefix+name, roleEntry)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for static role")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getStaticRole gets the static role from the Vault storage API
+func getStaticRole(ctx context.Context, s logical.Storage, name string) (*harborStaticRoleEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing static role name")
+	}
+
+	entry, err := s.Get(ctx, staticRoleStoragePrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role harborStaticRoleEntry
+
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
@@ -0,0 +1,148 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const staticRoleName = "replication"
+
+// TestStaticRole uses a fake Harbor API to check static roles
+// and the rotation of the secret of their robot account.
+func TestStaticRole(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	t.Run("Create Static Role-missing robot", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, "static-roles/"+staticRoleName, map[string]interface{}{
+			"rotation_period": "1h",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role-robot not found", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, "static-roles/"+staticRoleName, map[string]interface{}{
+			"robot_id":        8,
+			"rotation_period": "1h",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "not found")
+	})
+
+	t.Run("Create Static Role-rotation period too short", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, "static-roles/"+staticRoleName, map[string]interface{}{
+			"robot_name":      robotName,
+			"rotation_period": "10s",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create Static Role-pass", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, "static-roles/"+staticRoleName, map[string]interface{}{
+			"robot_name":      "vault",
+			"rotation_period": "1h",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, "static-roles/"+staticRoleName, nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), resp.Data["robot_id"])
+		require.Equal(t, robotName, resp.Data["robot_name"])
+		require.Equal(t, int64(3600), resp.Data["rotation_period"])
+
+		resp, err = testStaticRoleRequest(b, s, logical.ListOperation, "static-roles/", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{staticRoleName}, resp.Data["keys"])
+	})
+
+	t.Run("Read Static Creds", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.ReadOperation, "static-creds/"+staticRoleName, nil)
+		require.NoError(t, err)
+		require.NotEqual(t, robotSecret, resp.Data["robot_account_secret"])
+		require.Equal(t, harborServer.RobotSecret, resp.Data["robot_account_secret"])
+		require.Equal(t, robotName, resp.Data["robot_account_name"])
+		require.InDelta(t, 3600, resp.Data["ttl"], 5)
+	})
+
+	t.Run("Update Static Role-robot cannot change", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, "static-roles/"+staticRoleName, map[string]interface{}{
+			"robot_id": 9,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Rotate Role", func(t *testing.T) {
+		previous := harborServer.RobotSecret
+
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, "rotate-role/"+staticRoleName, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+		require.NotEqual(t, previous, harborServer.RobotSecret)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, "static-creds/"+staticRoleName, nil)
+		require.NoError(t, err)
+		require.Equal(t, harborServer.RobotSecret, resp.Data["robot_account_secret"])
+	})
+
+	t.Run("Periodic Rotation", func(t *testing.T) {
+		previous := harborServer.RobotSecret
+
+		// nothing is due yet
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+		require.Equal(t, previous, harborServer.RobotSecret)
+
+		roleEntry, err := getStaticRole(context.Background(), s, staticRoleName)
+		require.NoError(t, err)
+		roleEntry.LastRotated = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, setStaticRole(context.Background(), s, staticRoleName, roleEntry))
+
+		require.NoError(t, b.rotateStaticRoles(context.Background(), s))
+		require.NotEqual(t, previous, harborServer.RobotSecret)
+
+		roleEntry, err = getStaticRole(context.Background(), s, staticRoleName)
+		require.NoError(t, err)
+		require.Equal(t, harborServer.RobotSecret, roleEntry.Secret)
+		require.WithinDuration(t, time.Now(), roleEntry.LastRotated, time.Minute)
+	})
+
+	t.Run("Delete Static Role", func(t *testing.T) {
+		_, err := testStaticRoleRequest(b, s, logical.DeleteOperation, "static-roles/"+staticRoleName, nil)
+		require.NoError(t, err)
+
+		resp, err := testStaticRoleRequest(b, s, logical.ReadOperation, "static-creds/"+staticRoleName, nil)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+}
+
+func testStaticRoleRequest(
+	b logical.Backend,
+	s logical.Storage,
+	op logical.Operation,
+	path string,
+	d map[string]interface{},
+) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Data:      d,
+		Storage:   s,
+	})
+}
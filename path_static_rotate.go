@@ -0,0 +1,143 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRotateRoleHelpSynopsis    = `Rotate the secret of the Harbor robot account of a static role.`
+	pathRotateRoleHelpDescription = `
+This path refreshes the secret of the robot account bound to a static role now,
+instead of waiting for its rotation_period to elapse.
+`
+)
+
+// pathRotateRole extends the Vault API with a `/rotate-role`
+// endpoint for the static roles.
+func pathRotateRole(b *harborBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rotate-role/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the static role",
+				Required:    true,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathRotateRoleUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathRotateRoleHelpSynopsis,
+		HelpDescription: pathRotateRoleHelpDescription,
+	}
+}
+
+// pathRotateRoleUpdate rotates the secret of a static role now.
+func (b *harborBackend) pathRotateRoleUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	roleEntry, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("static role %q not found", name), nil
+	}
+
+	if err := b.rotateAndStoreStaticRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// rotateStaticRoles rotates the secrets of the static
+// roles whose rotation period has elapsed.
+func (b *harborBackend) rotateStaticRoles(ctx context.Context, s logical.Storage) error {
+	b.staticRoleLock.Lock()
+	defer b.staticRoleLock.Unlock()
+
+	names, err := s.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		roleEntry, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			b.Logger().Error("error reading static role", "static_role", name, "error", err)
+			continue
+		}
+
+		if roleEntry == nil || time.Now().Before(roleEntry.nextRotation()) {
+			continue
+		}
+
+		// a failed rotation is retried on the next run,
+		// it must not hold back the other static roles
+		if err := b.rotateAndStoreStaticRole(ctx, s, name, roleEntry); err != nil {
+			b.Logger().Error("error rotating static role secret", "static_role", name, "error", err)
+			continue
+		}
+
+		b.Logger().Info("rotated static role secret", "static_role", name)
+	}
+
+	return nil
+}
+
+// rotateAndStoreStaticRole rotates the secret of a static role and stores it.
+func (b *harborBackend) rotateAndStoreStaticRole(
+	ctx context.Context,
+	s logical.Storage,
+	name string,
+	roleEntry *harborStaticRoleEntry,
+) error {
+	client, err := b.getClient(ctx, s, roleEntry.Connection)
+	if err != nil {
+		return err
+	}
+
+	if err := rotateStaticRole(ctx, client, roleEntry); err != nil {
+		return err
+	}
+
+	if err := setStaticRole(ctx, s, name, roleEntry); err != nil {
+		// The secret was changed in Harbor already, so surface
+		// the failure loudly: the stored secret is stale now.
+		return fmt.Errorf("error storing rotated secret of static role %q, it must be rotated again: %w", name, err)
+	}
+
+	return nil
+}
+
+// rotateStaticRole refreshes the secret of the robot account of a static role.
+func rotateStaticRole(ctx context.Context, client *harborClient, roleEntry *harborStaticRoleEntry) error {
+	newSecret, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	secret, err := client.refreshRobotSecret(ctx, roleEntry.RobotID, newSecret)
+	if err != nil {
+		return fmt.Errorf("error refreshing Harbor robot account secret: %w", err)
+	}
+
+	roleEntry.Secret = secret
+	roleEntry.LastRotated = time.Now()
+
+	return nil
+}
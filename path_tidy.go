@@ -0,0 +1,613 @@
+package harbor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathTidyHelpSynopsis    = `Clean up the orphaned robot accounts the backend created in Harbor.`
+	pathTidyHelpDescription = `
+This path looks for the robot accounts in Harbor whose name starts with name_prefix,
+which the backend doesn't know as live anymore: a robot account whose lease was lost,
+after a mount was force-disabled, a restore from backup or a failed revocation.
+The orphans older than safety_buffer are deleted, or disabled, from Harbor.
+The robot accounts stamped by another mount are left alone, and so are the
+robot accounts without a stamp, unless include_unstamped is set: they may be
+live leases issued before the stamping, or belong to another mount.
+With dry_run, the orphans are only reported.
+`
+
+	pathConfigAutoTidyHelpSynopsis    = `Configure the periodic tidy of the orphaned robot accounts.`
+	pathConfigAutoTidyHelpDescription = `
+When enabled, the tidy operation runs every interval, with the given settings.
+`
+
+	robotDescriptionText = "This robot account is created by Vault, please DO NOT edit!"
+
+	tidyActionDelete  = "delete"
+	tidyActionDisable = "disable"
+
+	defaultTidySafetyBuffer = 72 * time.Hour
+	defaultTidyNamePrefix   = "vault."
+	defaultAutoTidyInterval = 12 * time.Hour
+
+	autoTidyStoragePath = "auto-tidy"
+)
+
+// robotMountRegex finds the mount stamped on the description of a robot account
+var robotMountRegex = regexp.MustCompile(`\(mount ([0-9A-Za-z-]+)\)`)
+
+// robotRevokedMarker starts the revocation stamped on the
+// description of the robot accounts disabled on revocation
+const robotRevokedMarker = "Revoked at "
+
+// robotDescription returns the description of the robot accounts
+// created by the backend, stamped with the mount they belong to.
+func robotDescription(backendUUID string) string {
+	if backendUUID == "" {
+		return robotDescriptionText
+	}
+
+	return fmt.Sprintf("%s (mount %s)", robotDescriptionText, backendUUID)
+}
+
+// robotRevokedDescription returns the description of a robot account disabled
+// on revocation, stamped with the revocation time and the lease, when known.
+func robotRevokedDescription(backendUUID string, revokedAt time.Time, leaseID string) string {
+	description := fmt.Sprintf("%s %s%s", robotDescription(backendUUID), robotRevokedMarker, revokedAt.UTC().Format(time.RFC3339))
+	if leaseID == "" {
+		return description
+	}
+
+	return fmt.Sprintf("%s, lease %s", description, leaseID)
+}
+
+// tidyOptions are the settings of a tidy operation
+type tidyOptions struct {
+	Connection   string        `json:"-"`
+	DryRun       bool          `json:"-"`
+	SafetyBuffer time.Duration `json:"safety_buffer"`
+	NamePrefix   string        `json:"name_prefix"`
+	Action       string        `json:"action"`
+
+	// IncludeUnstamped also removes the robot accounts
+	// without the stamp of a mount on their description
+	IncludeUnstamped bool `json:"include_unstamped"`
+}
+
+// autoTidyConfig is the configuration of the periodic tidy
+type autoTidyConfig struct {
+	tidyOptions
+
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval"`
+	LastRun  time.Time     `json:"last_run"`
+}
+
+// tidyOrphan is an orphaned robot account found by a tidy operation
+type tidyOrphan struct {
+	Connection   string
+	ID           int64
+	Name         string
+	CreationTime time.Time
+	Error        error
+}
+
+func (o *tidyOrphan) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"connection":    o.Connection,
+		"id":            o.ID,
+		"name":          o.Name,
+		"creation_time": o.CreationTime.Format(time.RFC3339),
+	}
+	if o.Error != nil {
+		data["error"] = o.Error.Error()
+	}
+
+	return data
+}
+
+// tidyFields are the fields shared by the tidy and the auto tidy paths
+func tidyFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"safety_buffer": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Only the orphaned robot accounts created longer ago than this are removed. Defaults to 72h.",
+			Default:     int(defaultTidySafetyBuffer.Seconds()),
+		},
+		"name_prefix": {
+			Type:        framework.TypeString,
+			Description: "Prefix of the names of the robot accounts created by the backend. Defaults to \"vault.\".",
+			Default:     defaultTidyNamePrefix,
+		},
+		"action": {
+			Type:          framework.TypeString,
+			Description:   "What to do with the orphaned robot accounts, delete or disable them. Defaults to delete.",
+			Default:       tidyActionDelete,
+			AllowedValues: []interface{}{tidyActionDelete, tidyActionDisable},
+		},
+		"include_unstamped": {
+			Type: framework.TypeBool,
+			Description: "Also remove the orphaned robot accounts without the stamp of a mount on their description. " +
+				"They may be live leases issued before the robot accounts were stamped, or belong to another mount.",
+		},
+	}
+}
+
+// pathTidy extends the Vault API with a `/tidy` endpoint for the backend.
+func pathTidy(b *harborBackend) *framework.Path {
+	fields := tidyFields()
+	fields["dry_run"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Only report the orphaned robot accounts, without removing them",
+	}
+	fields["connection"] = &framework.FieldSchema{
+		Type:        framework.TypeLowerCaseString,
+		Description: "Name of the connection to tidy. If not set, all the connections are tidied.",
+	}
+
+	return &framework.Path{
+		Pattern: "tidy$",
+		Fields:  fields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathTidyUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    pathTidyHelpSynopsis,
+		HelpDescription: pathTidyHelpDescription,
+	}
+}
+
+// pathConfigAutoTidy extends the Vault API with a `/config/auto-tidy`
+// endpoint for the backend.
+func pathConfigAutoTidy(b *harborBackend) *framework.Path {
+	fields := tidyFields()
+	fields["enabled"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Run the tidy operation periodically",
+	}
+	fields["interval"] = &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: "Interval between two tidy operations. Defaults to 12h.",
+		Default:     int(defaultAutoTidyInterval.Seconds()),
+	}
+
+	return &framework.Path{
+		Pattern: "config/auto-tidy$",
+		Fields:  fields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigAutoTidyRead,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigAutoTidyWrite,
+			},
+		},
+		HelpSynopsis:    pathConfigAutoTidyHelpSynopsis,
+		HelpDescription: pathConfigAutoTidyHelpDescription,
+	}
+}
+
+// pathTidyUpdate runs a tidy operation and reports the orphaned robot accounts.
+func (b *harborBackend) pathTidyUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	opts := tidyOptions{
+		Connection:   d.Get("connection").(string),
+		DryRun:       d.Get("dry_run").(bool),
+		SafetyBuffer: time.Duration(d.Get("safety_buffer").(int)) * time.Second,
+		NamePrefix:   d.Get("name_prefix").(string),
+		Action:       d.Get("action").(string),
+
+		IncludeUnstamped: d.Get("include_unstamped").(bool),
+	}
+
+	if resp := validateTidyOptions(opts); resp != nil {
+		return resp, nil
+	}
+
+	orphans, err := b.tidyRobots(ctx, req.Storage, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	orphansData := make([]map[string]interface{}, 0, len(orphans))
+	failed := 0
+	for _, orphan := range orphans {
+		orphansData = append(orphansData, orphan.toResponseData())
+		if orphan.Error != nil {
+			failed++
+		}
+	}
+
+	removed := 0
+	if !opts.DryRun {
+		removed = len(orphans) - failed
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run": opts.DryRun,
+			"action":  opts.Action,
+			"orphans": orphansData,
+			"removed": removed,
+			"failed":  failed,
+		},
+	}, nil
+}
+
+// pathConfigAutoTidyRead reads the configuration of the periodic tidy.
+func (b *harborBackend) pathConfigAutoTidyRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getAutoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"enabled":       config.Enabled,
+		"interval":      int64(config.Interval.Seconds()),
+		"safety_buffer": int64(config.SafetyBuffer.Seconds()),
+		"name_prefix":   config.NamePrefix,
+		"action":        config.Action,
+		"last_run":      "",
+
+		"include_unstamped": config.IncludeUnstamped,
+	}
+	if !config.LastRun.IsZero() {
+		data["last_run"] = config.LastRun.Format(time.RFC3339)
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+// pathConfigAutoTidyWrite updates the configuration of the periodic tidy.
+func (b *harborBackend) pathConfigAutoTidyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getAutoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled, ok := d.GetOk("enabled"); ok {
+		config.Enabled = enabled.(bool)
+	}
+
+	if interval, ok := d.GetOk("interval"); ok {
+		config.Interval = time.Duration(interval.(int)) * time.Second
+	}
+
+	if safetyBuffer, ok := d.GetOk("safety_buffer"); ok {
+		config.SafetyBuffer = time.Duration(safetyBuffer.(int)) * time.Second
+	}
+
+	if namePrefix, ok := d.GetOk("name_prefix"); ok {
+		config.NamePrefix = namePrefix.(string)
+	}
+
+	if action, ok := d.GetOk("action"); ok {
+		config.Action = action.(string)
+	}
+
+	if includeUnstamped, ok := d.GetOk("include_unstamped"); ok {
+		config.IncludeUnstamped = includeUnstamped.(bool)
+	}
+
+	if config.Interval < minRotationPeriod {
+		return logical.ErrorResponse("interval must be at least %s", minRotationPeriod), nil
+	}
+
+	if resp := validateTidyOptions(config.tidyOptions); resp != nil {
+		return resp, nil
+	}
+
+	entry, err := logical.StorageEntryJSON(autoTidyStoragePath, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// validateTidyOptions checks the settings of a tidy operation.
+func validateTidyOptions(opts tidyOptions) *logical.Response {
+	if opts.NamePrefix == "" {
+		return logical.ErrorResponse("name_prefix cannot be empty, it protects the robot accounts not created by the backend")
+	}
+
+	if opts.SafetyBuffer < 0 {
+		return logical.ErrorResponse("safety_buffer cannot be negative")
+	}
+
+	if opts.Action != tidyActionDelete && opts.Action != tidyActionDisable {
+		return logical.ErrorResponse("invalid action %q, valid actions are: %s, %s", opts.Action, tidyActionDelete, tidyActionDisable)
+	}
+
+	return nil
+}
+
+// getAutoTidyConfig gets the configuration of the periodic tidy,
+// with the default settings when it was never written.
+func getAutoTidyConfig(ctx context.Context, s logical.Storage) (*autoTidyConfig, error) {
+	config := &autoTidyConfig{
+		tidyOptions: tidyOptions{
+			SafetyBuffer: defaultTidySafetyBuffer,
+			NamePrefix:   defaultTidyNamePrefix,
+			Action:       tidyActionDelete,
+		},
+		Interval: defaultAutoTidyInterval,
+	}
+
+	entry, err := s.Get(ctx, autoTidyStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, fmt.Errorf("error reading auto tidy configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// autoTidy runs the periodic tidy when it is enabled and due.
+func (b *harborBackend) autoTidy(ctx context.Context, s logical.Storage) error {
+	config, err := getAutoTidyConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if !config.Enabled || time.Now().Before(config.LastRun.Add(config.Interval)) {
+		return nil
+	}
+
+	orphans, err := b.tidyRobots(ctx, s, config.tidyOptions)
+	if err != nil {
+		return fmt.Errorf("error running auto tidy: %w", err)
+	}
+
+	b.Logger().Info("auto tidy finished", "orphans", len(orphans))
+
+	config.LastRun = time.Now()
+
+	entry, err := logical.StorageEntryJSON(autoTidyStoragePath, config)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// tidyRobots finds, and unless in dry run removes, the orphaned robot accounts.
+func (b *harborBackend) tidyRobots(ctx context.Context, s logical.Storage, opts tidyOptions) ([]*tidyOrphan, error) {
+	if !b.tidyLock.TryLock() {
+		return nil, errors.New("a tidy operation is already running")
+	}
+	defer b.tidyLock.Unlock()
+
+	connections := []string{opts.Connection}
+	if opts.Connection == "" {
+		var err error
+		if connections, err = listConnections(ctx, s); err != nil {
+			return nil, err
+		}
+	}
+
+	live, err := liveRobotIDs(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*tidyOrphan
+
+	for _, connection := range connections {
+		connectionOrphans, err := b.findOrphans(ctx, s, connection, opts, live[connection])
+		if err != nil {
+			return nil, fmt.Errorf("error tidying connection %s: %w", connectionDisplayName(connection), err)
+		}
+
+		for _, orphan := range connectionOrphans {
+			if !opts.DryRun {
+				orphan.Error = b.removeOrphan(ctx, s, orphan, opts.Action)
+				if orphan.Error != nil {
+					b.Logger().Error("error removing orphaned robot account", "robot_account", orphan.Name, "error", orphan.Error)
+				} else {
+					b.Logger().Info("removed orphaned robot account", "robot_account", orphan.Name, "action", opts.Action)
+				}
+			}
+			orphans = append(orphans, orphan)
+		}
+	}
+
+	return orphans, nil
+}
+
+// findOrphans lists the robot accounts of a connection created by
+// the backend, which it doesn't know as live anymore.
+func (b *harborBackend) findOrphans(
+	ctx context.Context,
+	s logical.Storage,
+	connection string,
+	opts tidyOptions,
+	live map[int64]bool,
+) ([]*tidyOrphan, error) {
+	config, err := getConfig(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("harbor connection %s is not configured", connectionDisplayName(connection))
+	}
+
+	client, err := b.getClient(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.capabilities.checkRobotAccounts(); err != nil {
+		return nil, err
+	}
+
+	robots, err := client.listRobots(ctx, opts.NamePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing Harbor robot accounts: %w", err)
+	}
+
+	cutoff := time.Now().Add(-opts.SafetyBuffer)
+
+	var orphans []*tidyOrphan
+	for _, robot := range robots {
+		switch {
+		case !strings.HasPrefix(robotShortName(robot.Name), opts.NamePrefix),
+			live[robot.ID],
+			config.AuthType == authTypeRobot && robotShortName(robot.Name) == robotShortName(config.RobotName),
+			!b.ownsRobot(robot, opts.IncludeUnstamped),
+			// kept disabled on purpose, by the revocation mode of their role
+			strings.Contains(robot.Description, robotRevokedMarker),
+			robot.CreationTime.IsZero() || robot.CreationTime.After(cutoff),
+			opts.Action == tidyActionDisable && robot.Disable:
+			continue
+		}
+
+		orphans = append(orphans, &tidyOrphan{
+			Connection:   connection,
+			ID:           robot.ID,
+			Name:         robot.Name,
+			CreationTime: robot.CreationTime,
+		})
+	}
+
+	return orphans, nil
+}
+
+// ownsRobot tells whether a robot account belongs to the mount, from the
+// mount stamped on its description. The robot accounts without a stamp
+// can't be told apart: they are only owned when includeUnstamped is set.
+func (b *harborBackend) ownsRobot(robot *harborRobot, includeUnstamped bool) bool {
+	matches := robotMountRegex.FindStringSubmatch(robot.Description)
+	if matches == nil {
+		return includeUnstamped
+	}
+
+	return matches[1] == b.backendUUID
+}
+
+// removeOrphan deletes or disables an orphaned robot account.
+func (b *harborBackend) removeOrphan(ctx context.Context, s logical.Storage, orphan *tidyOrphan, action string) error {
+	client, err := b.getClient(ctx, s, orphan.Connection)
+	if err != nil {
+		return err
+	}
+
+	if action == tidyActionDisable {
+		robot, err := client.getRobot(ctx, orphan.ID)
+		if err != nil {
+			return err
+		}
+		robot.Disable = true
+
+		return client.updateRobot(ctx, robot)
+	}
+
+	err = client.deleteRobot(ctx, orphan.ID)
+	if isHarborNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// robotShortName returns the name of a robot account without the robot
+// prefix, nor the project of the project robot accounts.
+func robotShortName(name string) string {
+	if i := strings.Index(name, "$"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	if i := strings.Index(name, "+"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+// listConnections returns the configured connections.
+func listConnections(ctx context.Context, s logical.Storage) ([]string, error) {
+	var connections []string
+
+	config, err := getConfig(ctx, s, defaultConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		connections = append(connections, defaultConnection)
+	}
+
+	named, err := s.List(ctx, configStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(connections, named...), nil
+}
+
+// liveRobotIDs returns the IDs of the robot accounts the backend knows as
+// live, per connection: the recorded robot accounts and the static roles ones.
+func liveRobotIDs(ctx context.Context, s logical.Storage) (map[string]map[int64]bool, error) {
+	live := make(map[string]map[int64]bool)
+	add := func(connection string, id int64) {
+		if live[connection] == nil {
+			live[connection] = make(map[int64]bool)
+		}
+		live[connection][id] = true
+	}
+
+	names, err := s.List(ctx, robotStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		record, err := getRobotRecord(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			add(record.Connection, record.ID)
+		}
+	}
+
+	names, err = s.List(ctx, staticRoleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		role, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			add(role.Connection, role.RobotID)
+		}
+	}
+
+	return live, nil
+}
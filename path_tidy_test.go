@@ -0,0 +1,173 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// newTidyFakeHarbor starts a fake Harbor API listing robot accounts
+// in the various states the tidy operation tells apart.
+func newTidyFakeHarbor(t *testing.T) *fakeHarbor {
+	t.Helper()
+
+	old := time.Now().Add(-100 * time.Hour).Format(time.RFC3339)
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	harborServer := newFakeHarbor(t)
+	stamped := robotDescription("mount-a")
+
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(10), "name": "robot$vault.role.1", "creation_time": old, "description": stamped},
+		{"id": int64(11), "name": "robot$vault.role.2", "creation_time": old, "description": stamped},
+		{"id": int64(12), "name": "robot$vault.role.3", "creation_time": recent, "description": stamped},
+		// a lease issued before the stamping, without a record
+		{"id": int64(13), "name": "robot$library+vault.role.4", "creation_time": old},
+		{"id": int64(14), "name": "robot$vault.role.5", "creation_time": old, "description": robotDescription("mount-b")},
+		{"id": int64(15), "name": "robot$ci-vault.role", "creation_time": old, "description": stamped},
+		{"id": int64(16), "name": "robot$vault.static", "creation_time": old, "description": stamped},
+	}
+
+	return harborServer
+}
+
+func TestTidy(t *testing.T) {
+	setup := func(t *testing.T) (*harborBackend, logical.Storage, *fakeHarbor) {
+		b, s := getTestBackend(t)
+		b.backendUUID = "mount-a"
+
+		harborServer := newTidyFakeHarbor(t)
+
+		err := testConfigCreate(b, s, map[string]interface{}{
+			"username": username,
+			"password": password,
+			"url":      harborServer.URL,
+		})
+		require.NoError(t, err)
+
+		err = putRobotRecord(context.Background(), s, "vault.role.2", &harborRobotRecord{
+			ID:        11,
+			Name:      "robot$vault.role.2",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		err = setStaticRole(context.Background(), s, "static", &harborStaticRoleEntry{
+			RobotID:        16,
+			RobotName:      "robot$vault.static",
+			RotationPeriod: time.Hour,
+		})
+		require.NoError(t, err)
+
+		return b, s, harborServer
+	}
+
+	tidy := func(b *harborBackend, s logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tidy",
+			Storage:   s,
+			Data:      data,
+		})
+	}
+
+	orphanIDs := func(resp *logical.Response) []int64 {
+		var ids []int64
+		for _, orphan := range resp.Data["orphans"].([]map[string]interface{}) {
+			ids = append(ids, orphan["id"].(int64))
+		}
+		return ids
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		resp, err := tidy(b, s, map[string]interface{}{"dry_run": true})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Equal(t, []int64{10}, orphanIDs(resp))
+		require.Equal(t, 0, resp.Data["removed"])
+		require.Empty(t, harborServer.DeletedRobots)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		resp, err := tidy(b, s, nil)
+		require.NoError(t, err)
+		require.Equal(t, []int64{10}, orphanIDs(resp))
+		require.Equal(t, 1, resp.Data["removed"])
+		require.Equal(t, []int64{10}, harborServer.DeletedRobots)
+	})
+
+	t.Run("unstamped lease survives", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		_, err := tidy(b, s, map[string]interface{}{"safety_buffer": "1m"})
+		require.NoError(t, err)
+		require.NotContains(t, harborServer.DeletedRobots, int64(13))
+	})
+
+	t.Run("include unstamped", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		resp, err := tidy(b, s, map[string]interface{}{"include_unstamped": true})
+		require.NoError(t, err)
+		require.Equal(t, []int64{10, 13}, orphanIDs(resp))
+		require.Equal(t, []int64{10, 13}, harborServer.DeletedRobots)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		_, err := tidy(b, s, map[string]interface{}{"action": "disable", "safety_buffer": "30m"})
+		require.NoError(t, err)
+		require.Equal(t, []int64{10, 12}, harborServer.DisabledRobots)
+		require.Empty(t, harborServer.DeletedRobots)
+	})
+
+	t.Run("empty name prefix", func(t *testing.T) {
+		b, s, _ := setup(t)
+
+		resp, err := tidy(b, s, map[string]interface{}{"name_prefix": ""})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("auto tidy", func(t *testing.T) {
+		b, s, harborServer := setup(t)
+
+		// disabled by default
+		require.NoError(t, b.autoTidy(context.Background(), s))
+		require.Empty(t, harborServer.DeletedRobots)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/auto-tidy",
+			Storage:   s,
+			Data:      map[string]interface{}{"enabled": true, "interval": "1h"},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.autoTidy(context.Background(), s))
+		require.Equal(t, []int64{10}, harborServer.DeletedRobots)
+
+		// not due before the interval
+		require.NoError(t, b.autoTidy(context.Background(), s))
+		require.Len(t, harborServer.DeletedRobots, 1)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/auto-tidy",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data["enabled"])
+		require.Equal(t, int64(3600), resp.Data["interval"])
+		require.NotEmpty(t, resp.Data["last_run"])
+		require.Equal(t, false, resp.Data["include_unstamped"])
+	})
+}
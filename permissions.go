@@ -0,0 +1,425 @@
+package harbor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+)
+
+const (
+	// wildcard grants every resource or every action
+	wildcard = "*"
+
+	// systemNamespace is the only namespace of the system permissions
+	systemNamespace = "/"
+
+	effectAllow = "allow"
+	effectDeny  = "deny"
+)
+
+// robotPermissionCatalog lists, per permission kind, the resources
+// Harbor lets robot accounts be granted and the actions on them.
+// source: https://github.com/goharbor/harbor/blob/main/src/common/rbac/const.go
+var robotPermissionCatalog = map[string]map[string][]string{
+	permissionKindProject: {
+		"accessory":           {"list"},
+		"artifact":            {"create", "delete", "list", "read"},
+		"artifact-addition":   {"read"},
+		"artifact-label":      {"create", "delete"},
+		"helm-chart":          {"list", "read"},
+		"helm-chart-version":  {"create", "delete", "list", "read"},
+		"immutable-tag":       {"create", "delete", "list", "update"},
+		"label":               {"create", "delete", "list", "read", "update"},
+		"log":                 {"list"},
+		"member":              {"create", "delete", "list", "read", "update"},
+		"metadata":            {"create", "delete", "list", "read", "update"},
+		"notification-policy": {"create", "delete", "list", "read", "update"},
+		"preheat-policy":      {"create", "delete", "list", "read", "update"},
+		"project":             {"read"},
+		"quota":               {"read"},
+		"repository":          {"delete", "list", "pull", "push", "read", "update"},
+		"sbom":                {"create", "read", "stop"},
+		"scan":                {"create", "read", "stop"},
+		"scanner":             {"create", "read"},
+		"tag":                 {"create", "delete", "list"},
+		"tag-retention":       {"create", "delete", "list", "operate", "read", "update"},
+	},
+	permissionKindSystem: {
+		"audit-log":           {"list"},
+		"catalog":             {"read"},
+		"export-cve":          {"create", "read"},
+		"garbage-collection":  {"create", "list", "read", "stop", "update"},
+		"jobservice-monitor":  {"list", "stop"},
+		"label":               {"create", "delete", "read", "update"},
+		"ldap-user":           {"create", "list"},
+		"preheat-instance":    {"create", "delete", "list", "read", "update"},
+		"project":             {"create", "list"},
+		"purge-audit":         {"create", "list", "read", "stop", "update"},
+		"quota":               {"list", "read", "update"},
+		"registry":            {"create", "delete", "list", "read", "update"},
+		"replication":         {"create", "list", "read"},
+		"replication-adapter": {"list"},
+		"replication-policy":  {"create", "delete", "list", "read", "update"},
+		"robot":               {"create", "delete", "list", "read", "update"},
+		"scan-all":            {"create", "read", "stop", "update"},
+		"scanner":             {"create", "delete", "list", "read", "update"},
+		"security-hub":        {"list", "read"},
+		"system-volumes":      {"read"},
+		"user":                {"create", "delete", "list", "read", "update"},
+		"user-group":          {"create", "delete", "list", "read", "update"},
+	},
+}
+
+// parsePermissions parses the permissions of a role. Each item is
+// either a permission object, or a JSON string holding a permission
+// object or a list of them, as written by `permissions=@file.json`.
+func parsePermissions(raw []interface{}) ([]*harborModel.RobotPermission, error) {
+	permissions := make([]*harborModel.RobotPermission, 0) // non-nil to avoid a "missing permissions" error later
+
+	for _, item := range raw {
+		var encoded []byte
+
+		switch v := item.(type) {
+		case string:
+			encoded = []byte(strings.TrimSpace(v))
+		default:
+			var err error
+			if encoded, err = json.Marshal(v); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(encoded) > 0 && encoded[0] == '[' {
+			var list []*harborModel.RobotPermission
+			if err := jsonutil.DecodeJSON(encoded, &list); err != nil {
+				return nil, err
+			}
+			permissions = append(permissions, list...)
+			continue
+		}
+
+		permission := new(harborModel.RobotPermission)
+		if err := jsonutil.DecodeJSON(encoded, permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// validatePermissions checks the permissions of a role
+// against the catalog of robot account permissions.
+func validatePermissions(permissions []*harborModel.RobotPermission) error {
+	for i, permission := range permissions {
+		if err := validatePermission(permission); err != nil {
+			return fmt.Errorf("permission %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func validatePermission(permission *harborModel.RobotPermission) error {
+	if permission == nil {
+		return fmt.Errorf("permission is empty")
+	}
+
+	resources, ok := robotPermissionCatalog[permission.Kind]
+	if !ok {
+		return fmt.Errorf("invalid kind %q, valid kinds are: %s, %s", permission.Kind, permissionKindProject, permissionKindSystem)
+	}
+
+	switch permission.Kind {
+	case permissionKindSystem:
+		if permission.Namespace != systemNamespace {
+			return fmt.Errorf("invalid namespace %q, the namespace of kind %s must be %q",
+				permission.Namespace, permissionKindSystem, systemNamespace)
+		}
+	case permissionKindProject:
+		if permission.Namespace == "" {
+			return fmt.Errorf("missing namespace, the namespace of kind %s is a project name, a glob or regex pattern, "+
+				"or %q for all projects", permissionKindProject, allProjectsNamespace)
+		}
+
+		if isNamespaceTemplate(permission.Namespace) {
+			if err := checkNamespaceTemplate(permission.Namespace); err != nil {
+				return err
+			}
+		} else if isNamespacePattern(permission.Namespace) {
+			if _, err := compileNamespacePattern(permission.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(permission.Access) == 0 {
+		return fmt.Errorf("missing access")
+	}
+
+	for _, access := range permission.Access {
+		if access == nil {
+			return fmt.Errorf("access is empty")
+		}
+
+		if err := validateAccess(permission.Kind, resources, access); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateAccess(kind string, resources map[string][]string, access *harborModel.Access) error {
+	if access.Effect != "" && access.Effect != effectAllow && access.Effect != effectDeny {
+		return fmt.Errorf("invalid effect %q, valid effects are: %s, %s", access.Effect, effectAllow, effectDeny)
+	}
+
+	if access.Resource == wildcard {
+		if access.Action != wildcard && !containsString(allActions(resources), access.Action) {
+			return fmt.Errorf("invalid action %q for all resources of kind %s, valid actions are: %s",
+				access.Action, kind, strings.Join(append([]string{wildcard}, allActions(resources)...), ", "))
+		}
+		return nil
+	}
+
+	actions, ok := resources[access.Resource]
+	if !ok {
+		return fmt.Errorf("invalid resource %q for kind %s, valid resources are: %s",
+			access.Resource, kind, strings.Join(append([]string{wildcard}, sortedKeys(resources)...), ", "))
+	}
+
+	if access.Action != wildcard && !containsString(actions, access.Action) {
+		return fmt.Errorf("invalid action %q for resource %q, valid actions are: %s",
+			access.Action, access.Resource, strings.Join(append([]string{wildcard}, actions...), ", "))
+	}
+
+	return nil
+}
+
+// permissionsResponseData returns the permissions as response data
+func permissionsResponseData(permissions []*harborModel.RobotPermission) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(permissions))
+
+	for _, permission := range permissions {
+		access := make([]map[string]interface{}, 0, len(permission.Access))
+		for _, a := range permission.Access {
+			item := map[string]interface{}{
+				"resource": a.Resource,
+				"action":   a.Action,
+			}
+			if a.Effect != "" {
+				item["effect"] = a.Effect
+			}
+			access = append(access, item)
+		}
+
+		data = append(data, map[string]interface{}{
+			"kind":      permission.Kind,
+			"namespace": permission.Namespace,
+			"access":    access,
+		})
+	}
+
+	return data
+}
+
+// allActions returns every action of the resources, sorted.
+func allActions(resources map[string][]string) []string {
+	seen := make(map[string]bool)
+	for _, actions := range resources {
+		for _, action := range actions {
+			seen[action] = true
+		}
+	}
+
+	return sortedKeys(seen)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errOutsideRole is returned when the credentials
+// ask for more than their role allows.
+var errOutsideRole = errors.New("outside of the role")
+
+// credsScope narrows the robot account of a credentials
+// request within what its role allows.
+type credsScope struct {
+	// Projects are the only projects the robot account gets permissions on
+	Projects []string
+	// Actions are the only actions the robot account gets,
+	// either `<action>` or `<resource>:<action>`
+	Actions []string
+}
+
+// narrowPermissions restricts the permissions of a role to the projects and
+// actions of the scope. Every project and action of the scope must be allowed
+// by the role. The permissions of the role are left untouched.
+func narrowPermissions(permissions []*harborModel.RobotPermission, scope credsScope) ([]*harborModel.RobotPermission, error) {
+	if len(scope.Projects) > 0 {
+		var err error
+		if permissions, err = narrowProjects(permissions, scope.Projects); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(scope.Actions) > 0 {
+		var err error
+		if permissions, err = narrowActions(permissions, scope.Actions); err != nil {
+			return nil, err
+		}
+	}
+
+	return permissions, nil
+}
+
+// intersectScope returns the part of a scope the permissions still grant: the
+// projects and actions they don't grant anymore are dropped. It returns false
+// when the scope was narrowed and nothing of it is left.
+func intersectScope(permissions []*harborModel.RobotPermission, scope credsScope) (credsScope, bool) {
+	var intersection credsScope
+
+	for _, project := range scope.Projects {
+		if _, err := narrowProjects(permissions, []string{project}); err == nil {
+			intersection.Projects = append(intersection.Projects, project)
+		}
+	}
+
+	if len(scope.Projects) > 0 {
+		if len(intersection.Projects) == 0 {
+			return credsScope{}, false
+		}
+
+		// the projects are granted, narrowing to them can't fail
+		permissions, _ = narrowProjects(permissions, intersection.Projects)
+	}
+
+	for _, action := range scope.Actions {
+		if _, err := narrowActions(permissions, []string{action}); err == nil {
+			intersection.Actions = append(intersection.Actions, action)
+		}
+	}
+
+	if len(scope.Actions) > 0 && len(intersection.Actions) == 0 {
+		return credsScope{}, false
+	}
+
+	return intersection, true
+}
+
+// narrowProjects keeps the project permissions on the given projects only,
+// the permissions of kind system are dropped.
+func narrowProjects(permissions []*harborModel.RobotPermission, projects []string) ([]*harborModel.RobotPermission, error) {
+	narrowed := make([]*harborModel.RobotPermission, 0, len(projects))
+
+	for _, project := range projects {
+		var access []*harborModel.Access
+		for _, permission := range permissions {
+			if permission.Kind == permissionKindProject &&
+				(permission.Namespace == project || permission.Namespace == allProjectsNamespace) {
+				access = appendAccess(access, permission.Access...)
+			}
+		}
+
+		if access == nil {
+			return nil, fmt.Errorf("project %q is %w", project, errOutsideRole)
+		}
+
+		narrowed = append(narrowed, &harborModel.RobotPermission{
+			Kind:      permissionKindProject,
+			Namespace: project,
+			Access:    access,
+		})
+	}
+
+	return narrowed, nil
+}
+
+// narrowActions keeps the accesses granting the given actions only. The denied
+// accesses of the role are kept, as they can only restrict the robot account.
+func narrowActions(permissions []*harborModel.RobotPermission, actions []string) ([]*harborModel.RobotPermission, error) {
+	granted := make(map[string]bool, len(actions))
+	narrowed := make([]*harborModel.RobotPermission, 0, len(permissions))
+
+	for _, permission := range permissions {
+		var access, denied []*harborModel.Access
+
+		for _, a := range permission.Access {
+			if a.Effect == effectDeny {
+				denied = append(denied, a)
+				continue
+			}
+
+			for _, requested := range actions {
+				if narrowedAccess := narrowAccess(permission.Kind, a, requested); narrowedAccess != nil {
+					access = appendAccess(access, narrowedAccess)
+					granted[requested] = true
+				}
+			}
+		}
+
+		// a permission which grants none of the actions is dropped
+		if access == nil {
+			continue
+		}
+
+		narrowed = append(narrowed, &harborModel.RobotPermission{
+			Kind:      permission.Kind,
+			Namespace: permission.Namespace,
+			Access:    appendAccess(access, denied...),
+		})
+	}
+
+	for _, requested := range actions {
+		if !granted[requested] {
+			return nil, fmt.Errorf("action %q is %w", requested, errOutsideRole)
+		}
+	}
+
+	return narrowed, nil
+}
+
+// narrowAccess returns the part of an access granting the requested
+// `<action>` or `<resource>:<action>`, or nil if it doesn't grant it.
+// An access to all actions only grants the actions of its resource.
+func narrowAccess(kind string, access *harborModel.Access, requested string) *harborModel.Access {
+	resource, action, scoped := strings.Cut(requested, ":")
+	if !scoped {
+		resource, action = access.Resource, requested
+	}
+
+	if (access.Resource != wildcard && access.Resource != resource) ||
+		(access.Action != wildcard && access.Action != action) {
+		return nil
+	}
+
+	if access.Action == wildcard && resource != wildcard && action != wildcard &&
+		!containsString(robotPermissionCatalog[kind][resource], action) {
+		return nil
+	}
+
+	return &harborModel.Access{Resource: resource, Action: action, Effect: access.Effect}
+}
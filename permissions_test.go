@@ -0,0 +1,191 @@
+package harbor
+
+import (
+	"testing"
+
+	harborModel "github.com/mittwald/goharbor-client/v5/apiv2/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePermissions(t *testing.T) {
+	t.Run("JSON string", func(t *testing.T) {
+		permissions, err := parsePermissions([]interface{}{testPermissions})
+		require.NoError(t, err)
+		require.Len(t, permissions, 1)
+		require.Equal(t, "public", permissions[0].Namespace)
+		require.Equal(t, "repository", permissions[0].Access[0].Resource)
+	})
+
+	t.Run("objects", func(t *testing.T) {
+		permissions, err := parsePermissions([]interface{}{
+			map[string]interface{}{
+				"kind":      "system",
+				"namespace": "/",
+				"access": []interface{}{
+					map[string]interface{}{"resource": "project", "action": "create"},
+				},
+			},
+			`{"kind":"project","namespace":"*","access":[{"resource":"repository","action":"pull"}]}`,
+		})
+		require.NoError(t, err)
+		require.Len(t, permissions, 2)
+		require.Equal(t, "system", permissions[0].Kind)
+		require.Equal(t, "*", permissions[1].Namespace)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := parsePermissions([]interface{}{"[{"})
+		require.Error(t, err)
+	})
+}
+
+func TestValidatePermissions(t *testing.T) {
+	permission := func(kind, namespace, resource, action string) []*harborModel.RobotPermission {
+		return []*harborModel.RobotPermission{{
+			Kind:      kind,
+			Namespace: namespace,
+			Access:    []*harborModel.Access{{Resource: resource, Action: action}},
+		}}
+	}
+
+	tests := []struct {
+		name        string
+		permissions []*harborModel.RobotPermission
+		err         string
+	}{
+		{"project", permission("project", "public", "repository", "pull"), ""},
+		{"all projects", permission("project", "*", "artifact", "*"), ""},
+		{"system", permission("system", "/", "project", "create"), ""},
+		{"all resources", permission("project", "public", "*", "pull"), ""},
+		{"identity template", permission("project", "{{identity.entity.metadata.team}}", "repository", "push"), ""},
+		{"broken identity template", permission("project", "{{identity.entity.name", "repository", "push"), "invalid namespace template"},
+		{"invalid kind", permission("global", "/", "project", "create"), `invalid kind "global"`},
+		{"invalid system namespace", permission("system", "public", "project", "create"), `invalid namespace "public"`},
+		{"missing namespace", permission("project", "", "repository", "pull"), "missing namespace"},
+		{"invalid resource", permission("project", "public", "repo", "pull"), "valid resources are: *, accessory"},
+		{"invalid action", permission("project", "public", "repository", "pul"), "valid actions are: *, delete, list, pull, push"},
+		{"invalid action for all resources", permission("project", "public", "*", "pul"), `invalid action "pul" for all resources`},
+		{"system resource on project", permission("project", "public", "audit-log", "list"), `invalid resource "audit-log"`},
+		{"missing access", []*harborModel.RobotPermission{{Kind: "project", Namespace: "public"}}, "missing access"},
+		{
+			"invalid effect",
+			[]*harborModel.RobotPermission{{
+				Kind:      "project",
+				Namespace: "public",
+				Access:    []*harborModel.Access{{Resource: "repository", Action: "pull", Effect: "maybe"}},
+			}},
+			`invalid effect "maybe"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePermissions(tt.permissions)
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestNarrowPermissions(t *testing.T) {
+	role := []*harborModel.RobotPermission{
+		{
+			Kind:      "project",
+			Namespace: "team-a",
+			Access: []*harborModel.Access{
+				{Resource: "repository", Action: "pull"},
+				{Resource: "repository", Action: "push"},
+				{Resource: "artifact", Action: "*"},
+			},
+		},
+		{
+			Kind:      "project",
+			Namespace: "*",
+			Access:    []*harborModel.Access{{Resource: "repository", Action: "pull"}},
+		},
+		{
+			Kind:      "system",
+			Namespace: "/",
+			Access:    []*harborModel.Access{{Resource: "project", Action: "list"}},
+		},
+	}
+
+	t.Run("no scope", func(t *testing.T) {
+		narrowed, err := narrowPermissions(role, credsScope{})
+		require.NoError(t, err)
+		require.Equal(t, role, narrowed)
+	})
+
+	t.Run("projects", func(t *testing.T) {
+		narrowed, err := narrowPermissions(role, credsScope{Projects: []string{"team-a", "library"}})
+		require.NoError(t, err)
+		require.Len(t, narrowed, 2)
+		require.Equal(t, "team-a", narrowed[0].Namespace)
+		require.Len(t, narrowed[0].Access, 3)
+		require.Equal(t, "library", narrowed[1].Namespace)
+		require.Equal(t, []*harborModel.Access{{Resource: "repository", Action: "pull"}}, narrowed[1].Access)
+	})
+
+	t.Run("projects and actions", func(t *testing.T) {
+		narrowed, err := narrowPermissions(role, credsScope{Projects: []string{"team-a"}, Actions: []string{"pull", "artifact:delete"}})
+		require.NoError(t, err)
+		require.Equal(t, []*harborModel.RobotPermission{{
+			Kind:      "project",
+			Namespace: "team-a",
+			Access: []*harborModel.Access{
+				{Resource: "repository", Action: "pull"},
+				{Resource: "artifact", Action: "delete"},
+			},
+		}}, narrowed)
+	})
+
+	t.Run("deny is kept", func(t *testing.T) {
+		narrowed, err := narrowPermissions([]*harborModel.RobotPermission{{
+			Kind:      "project",
+			Namespace: "team-a",
+			Access: []*harborModel.Access{
+				{Resource: "*", Action: "*"},
+				{Resource: "repository", Action: "delete", Effect: "deny"},
+			},
+		}}, credsScope{Actions: []string{"repository:pull"}})
+		require.NoError(t, err)
+		require.Equal(t, []*harborModel.Access{
+			{Resource: "repository", Action: "pull"},
+			{Resource: "repository", Action: "delete", Effect: "deny"},
+		}, narrowed[0].Access)
+	})
+
+	t.Run("project outside of the role", func(t *testing.T) {
+		_, err := narrowPermissions(role[:1], credsScope{Projects: []string{"team-b"}})
+		require.ErrorIs(t, err, errOutsideRole)
+	})
+
+	t.Run("action outside of the role", func(t *testing.T) {
+		_, err := narrowPermissions(role, credsScope{Projects: []string{"library"}, Actions: []string{"push"}})
+		require.ErrorIs(t, err, errOutsideRole)
+	})
+
+	t.Run("role is untouched", func(t *testing.T) {
+		_, err := narrowPermissions(role, credsScope{Projects: []string{"team-a"}, Actions: []string{"pull"}})
+		require.NoError(t, err)
+		require.Len(t, role[0].Access, 3)
+		require.Equal(t, "*", role[0].Access[2].Action)
+	})
+
+	t.Run("intersect scope", func(t *testing.T) {
+		scope, ok := intersectScope(role[:1], credsScope{Projects: []string{"team-a", "team-b"}, Actions: []string{"pull", "repository:delete"}})
+		require.True(t, ok)
+		require.Equal(t, credsScope{Projects: []string{"team-a"}, Actions: []string{"pull"}}, scope)
+
+		scope, ok = intersectScope(role, credsScope{})
+		require.True(t, ok)
+		require.Equal(t, credsScope{}, scope)
+
+		_, ok = intersectScope(role, credsScope{Projects: []string{"library"}, Actions: []string{"push"}})
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,251 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// revocationStoragePrefix is the storage prefix of the pending revocations,
+	// keyed by the name the backend gave to the robot account.
+	revocationStoragePrefix = "revocation/"
+
+	// the retries of a pending revocation back off from the
+	// period of the periodic function up to an hour
+	minRevocationBackoff = time.Minute
+	maxRevocationBackoff = time.Hour
+)
+
+// pendingRevocation is a robot account whose lease was revoked while its
+// deletion from Harbor failed, or whose deletion is delayed. It is deleted,
+// or disabled, by the periodic function.
+type pendingRevocation struct {
+	ID          int64         `json:"id"`
+	Name        string        `json:"name"`
+	Connection  string        `json:"connection"`
+	Mode        string        `json:"mode"`
+	LeaseID     string        `json:"lease_id"`
+	RevokedAt   time.Time     `json:"revoked_at"`
+	DeleteAfter time.Duration `json:"delete_after"`
+	QueuedAt    time.Time     `json:"queued_at"`
+	Attempts    int           `json:"attempts"`
+	LastError   string        `json:"last_error"`
+	NextAttempt time.Time     `json:"next_attempt"`
+}
+
+// toResponseData returns response data for a pending revocation
+func (r *pendingRevocation) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"robot_account_id":   r.ID,
+		"robot_account_name": r.Name,
+		"connection":         r.Connection,
+		"mode":               r.mode(),
+		"lease_id":           r.LeaseID,
+		"queued_at":          r.QueuedAt.Format(time.RFC3339),
+		"attempts":           r.Attempts,
+		"last_error":         r.LastError,
+		"next_attempt":       r.NextAttempt.Format(time.RFC3339),
+	}
+}
+
+// mode returns the revocation mode, the revocations
+// queued before the modes were added are deletions.
+func (r *pendingRevocation) mode() string {
+	if r.Mode == "" {
+		return revocationModeDelete
+	}
+
+	return r.Mode
+}
+
+// applied moves a revocation to its next step once applied: the robot accounts
+// disabled then deleted are queued again for their deletion. It returns
+// whether the revocation is complete.
+func (r *pendingRevocation) applied() bool {
+	if r.mode() != revocationModeDisableThenDeleteAfter {
+		return true
+	}
+
+	r.Mode = revocationModeDelete
+	r.Attempts = 0
+	r.LastError = ""
+	r.NextAttempt = time.Now().Add(r.DeleteAfter)
+
+	return false
+}
+
+// failed records a failed attempt, and when to try again.
+func (r *pendingRevocation) failed(err error) {
+	r.Attempts++
+	r.LastError = err.Error()
+
+	backoff := maxRevocationBackoff
+	if r.Attempts < 8 {
+		backoff = min(minRevocationBackoff<<(r.Attempts-1), maxRevocationBackoff)
+	}
+	r.NextAttempt = time.Now().Add(backoff)
+}
+
+// putPendingRevocation stores a pending revocation
+func putPendingRevocation(ctx context.Context, s logical.Storage, name string, revocation *pendingRevocation) error {
+	entry, err := logical.StorageEntryJSON(revocationStoragePrefix+name, revocation)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for pending revocation")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getPendingRevocation gets a pending revocation
+func getPendingRevocation(ctx context.Context, s logical.Storage, name string) (*pendingRevocation, error) {
+	entry, err := s.Get(ctx, revocationStoragePrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation pendingRevocation
+
+	if err := entry.DecodeJSON(&revocation); err != nil {
+		return nil, err
+	}
+	return &revocation, nil
+}
+
+// listPendingRevocations returns the pending revocations
+func listPendingRevocations(ctx context.Context, s logical.Storage) ([]*pendingRevocation, error) {
+	names, err := s.List(ctx, revocationStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := make([]*pendingRevocation, 0, len(names))
+	for _, name := range names {
+		revocation, err := getPendingRevocation(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if revocation != nil {
+			revocations = append(revocations, revocation)
+		}
+	}
+
+	return revocations, nil
+}
+
+// revokeRobotAccount deletes or disables a robot account in Harbor, and queues
+// the delayed deletions, or the revocation when it fails. It returns whether
+// the revocation failed and was queued.
+func (b *harborBackend) revokeRobotAccount(ctx context.Context, s logical.Storage, revocation *pendingRevocation) (bool, error) {
+	revocation.RevokedAt = time.Now()
+	revocation.QueuedAt = revocation.RevokedAt
+
+	err := b.applyRevocation(ctx, s, revocation)
+	if err == nil && revocation.applied() {
+		return false, nil
+	}
+
+	if err != nil {
+		b.Logger().Warn("error revoking robot account, queueing its revocation", "robot_account", revocation.Name, "error", err)
+		revocation.failed(err)
+	}
+
+	if err := putPendingRevocation(ctx, s, revocation.Name, revocation); err != nil {
+		return false, fmt.Errorf("error queueing robot account revocation: %w", err)
+	}
+
+	return err != nil, nil
+}
+
+// applyRevocation deletes or disables the robot account of a revocation in
+// Harbor, a robot account which is already gone is revoked.
+func (b *harborBackend) applyRevocation(ctx context.Context, s logical.Storage, revocation *pendingRevocation) error {
+	client, err := b.getClient(ctx, s, revocation.Connection)
+	if err != nil {
+		return err
+	}
+
+	if revocation.mode() != revocationModeDelete {
+		return b.disableRevokedRobot(ctx, client, revocation)
+	}
+
+	err = deleteRobotAccount(ctx, client, revocation.ID, revocation.Name)
+	if err != nil && !isHarborNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// disableRevokedRobot disables the robot account of a revocation, and stamps
+// its description with the revocation time and lease, so that it is kept for
+// the audit logs to resolve.
+func (b *harborBackend) disableRevokedRobot(ctx context.Context, c *harborClient, revocation *pendingRevocation) error {
+	if err := c.capabilities.checkRobotAccounts(); err != nil {
+		return err
+	}
+
+	robot, err := findRobot(ctx, c, revocation.ID, revocation.Name)
+	if err != nil || robot == nil {
+		return err
+	}
+
+	robot.Disable = true
+	robot.Description = robotRevokedDescription(b.backendUUID, revocation.RevokedAt, revocation.LeaseID)
+
+	err = c.updateRobot(ctx, robot)
+	if err != nil && !isHarborNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// processRevocations applies the pending revocations which are due: the
+// failed ones are retried and the delayed deletions are carried out.
+func (b *harborBackend) processRevocations(ctx context.Context, s logical.Storage) error {
+	revocations, err := listPendingRevocations(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, revocation := range revocations {
+		if now.Before(revocation.NextAttempt) {
+			continue
+		}
+
+		err := b.applyRevocation(ctx, s, revocation)
+		if err != nil {
+			b.Logger().Error("error revoking robot account", "robot_account", revocation.Name, "attempts", revocation.Attempts+1, "error", err)
+			revocation.failed(err)
+		}
+
+		if err != nil || !revocation.applied() {
+			if err := putPendingRevocation(ctx, s, revocation.Name, revocation); err != nil {
+				b.Logger().Error("error updating pending revocation", "robot_account", revocation.Name, "error", err)
+			}
+			continue
+		}
+
+		if err := s.Delete(ctx, revocationStoragePrefix+revocation.Name); err != nil {
+			b.Logger().Error("error deleting pending revocation", "robot_account", revocation.Name, "error", err)
+			continue
+		}
+
+		b.Logger().Info("revoked robot account", "robot_account", revocation.Name, "mode", revocation.mode(), "queued_at", revocation.QueuedAt)
+	}
+
+	return nil
+}
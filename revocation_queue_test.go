@@ -0,0 +1,214 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRevocationQueue uses a fake Harbor API to check that the deletion of
+// a revoked robot account is queued while Harbor is unavailable.
+func TestRevocationQueue(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{{"id": int64(30), "name": "robot$vault.role.1"}}
+	harborServer.Unavailable = true
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username":    username,
+		"password":    password,
+		"url":         harborServer.URL,
+		"max_retries": 0,
+	})
+	require.NoError(t, err)
+
+	_, err = testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions": testPermissions,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, putRobotRecord(context.Background(), s, "vault.role.1", &harborRobotRecord{
+		ID: 30, Name: "robot$vault.role.1", Role: roleName, ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	secret := &logical.Secret{InternalData: map[string]interface{}{
+		"secret_type":        harborRobotAccountType,
+		"role":               roleName,
+		"robot_account_id":   int64(30),
+		"robot_account_name": "vault.role.1",
+	}}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    secret,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	readPending := func() []map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "revocations/pending",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		return resp.Data["pending"].([]map[string]interface{})
+	}
+
+	pending := readPending()
+	require.Len(t, pending, 1)
+	require.Equal(t, int64(30), pending[0]["robot_account_id"])
+	require.Equal(t, 1, pending[0]["attempts"])
+	require.NotEmpty(t, pending[0]["last_error"])
+
+	t.Run("not due", func(t *testing.T) {
+		require.NoError(t, b.processRevocations(context.Background(), s))
+		require.Len(t, readPending(), 1)
+	})
+
+	// makes the pending revocations due
+	due := func(name string) {
+		revocation, err := getPendingRevocation(context.Background(), s, name)
+		require.NoError(t, err)
+		revocation.NextAttempt = time.Now()
+		require.NoError(t, putPendingRevocation(context.Background(), s, name, revocation))
+	}
+
+	t.Run("still unavailable", func(t *testing.T) {
+		due("vault.role.1")
+		require.NoError(t, b.processRevocations(context.Background(), s))
+
+		pending := readPending()
+		require.Len(t, pending, 1)
+		require.Equal(t, 2, pending[0]["attempts"])
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		harborServer.Unavailable = false
+		due("vault.role.1")
+		require.NoError(t, b.processRevocations(context.Background(), s))
+
+		require.Equal(t, []int64{30}, harborServer.DeletedRobots)
+		require.Empty(t, readPending())
+	})
+
+	t.Run("already gone", func(t *testing.T) {
+		require.NoError(t, putPendingRevocation(context.Background(), s, "vault.role.2", &pendingRevocation{
+			Name: "vault.role.2",
+		}))
+		require.NoError(t, b.processRevocations(context.Background(), s))
+
+		require.Empty(t, readPending())
+	})
+
+	t.Run("legacy lease already gone", func(t *testing.T) {
+		// a lease created before the robot account ID was recorded
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret: &logical.Secret{InternalData: map[string]interface{}{
+				"secret_type":        harborRobotAccountType,
+				"role":               roleName,
+				"robot_account_name": "vault.role.3",
+			}},
+			Storage: s,
+		})
+		require.NoError(t, err)
+
+		require.Empty(t, readPending())
+		require.Equal(t, []int64{30}, harborServer.DeletedRobots)
+	})
+}
+
+// TestRevocationModes uses a fake Harbor API to check that the revoked or
+// expired robot accounts are disabled, then deleted when their role delays
+// their deletion.
+func TestRevocationModes(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(40), "name": "robot$vault.role.1"},
+		{"id": int64(41), "name": "robot$vault.role.2"},
+		{"id": int64(42), "name": "robot$vault.role.3"},
+	}
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	revoke := func(name, mode string, deleteAfter int64) {
+		secret := &logical.Secret{
+			LeaseID: "harbor/creds/test-role/" + name,
+			InternalData: map[string]interface{}{
+				"secret_type":             harborRobotAccountType,
+				"role":                    roleName,
+				"robot_account_name":      name,
+				"revocation_mode":         mode,
+				"revocation_delete_after": deleteAfter,
+			},
+		}
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("disable", func(t *testing.T) {
+		revoke("vault.role.1", revocationModeDisable, 0)
+
+		require.Equal(t, []int64{40}, harborServer.DisabledRobots)
+		require.Empty(t, harborServer.DeletedRobots)
+		require.Contains(t, harborServer.Robots[0]["description"], "Revoked at ")
+		require.Contains(t, harborServer.Robots[0]["description"], "lease harbor/creds/test-role/vault.role.1")
+
+		revocations, err := listPendingRevocations(context.Background(), s)
+		require.NoError(t, err)
+		require.Empty(t, revocations)
+	})
+
+	t.Run("disable then delete after", func(t *testing.T) {
+		revoke("vault.role.2", revocationModeDisableThenDeleteAfter, 3600)
+
+		require.Equal(t, []int64{40, 41}, harborServer.DisabledRobots)
+		require.Empty(t, harborServer.DeletedRobots)
+
+		revocation, err := getPendingRevocation(context.Background(), s, "vault.role.2")
+		require.NoError(t, err)
+		require.Equal(t, revocationModeDelete, revocation.Mode)
+		require.WithinDuration(t, time.Now().Add(time.Hour), revocation.NextAttempt, time.Minute)
+
+		require.NoError(t, b.processRevocations(context.Background(), s))
+		require.Empty(t, harborServer.DeletedRobots)
+
+		revocation.NextAttempt = time.Now()
+		require.NoError(t, putPendingRevocation(context.Background(), s, "vault.role.2", revocation))
+		require.NoError(t, b.processRevocations(context.Background(), s))
+		require.Equal(t, []int64{41}, harborServer.DeletedRobots)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		require.NoError(t, putRobotRecord(context.Background(), s, "vault.role.3", &harborRobotRecord{
+			ID:             42,
+			Name:           "robot$vault.role.3",
+			ExpiresAt:      time.Now().Add(-time.Minute),
+			RevocationMode: revocationModeDisable,
+		}))
+
+		require.NoError(t, b.enforceRobotExpiry(context.Background(), s))
+		require.Equal(t, []int64{40, 41, 42}, harborServer.DisabledRobots)
+		require.Equal(t, []int64{41}, harborServer.DeletedRobots)
+
+		record, err := getRobotRecord(context.Background(), s, "vault.role.3")
+		require.NoError(t, err)
+		require.Nil(t, record)
+	})
+}
@@ -2,8 +2,10 @@ package harbor
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -31,13 +33,9 @@ func (b *harborBackend) harborToken() *framework.Secret {
 
 // tokenRevoke removes the token from the Vault storage API and calls the client to revoke the robot account
 func (b *harborBackend) robotAccountRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	client, err := b.getClient(ctx, req.Storage)
-	if err != nil {
-		return nil, err
-	}
-	if client == nil {
-		return nil, fmt.Errorf("error getting Harbor client")
-	}
+	// Leases created before named connections were supported
+	// don't carry a connection, they belong to the default one.
+	connection, _ := req.Secret.InternalData["connection"].(string)
 
 	var account string
 	// We passed the account using InternalData from when we first created
@@ -53,23 +51,111 @@ func (b *harborBackend) robotAccountRevoke(ctx context.Context, req *logical.Req
 		return nil, fmt.Errorf("unable convert robot_account_name")
 	}
 
-	if err := deleteRobotAccount(ctx, client, account); err != nil {
-		return nil, fmt.Errorf("error revoking robot account: %w", err)
+	// Leases created before the robot account ID was recorded
+	// are revoked by looking the robot account up by name.
+	accountID, _ := internalDataInt64(req.Secret.InternalData, "robot_account_id")
+
+	record, err := getRobotRecord(ctx, req.Storage, account)
+	if err != nil {
+		return nil, fmt.Errorf("error reading robot account record: %w", err)
+	}
+
+	// the leases carrying the robot account ID were recorded, without its
+	// record the robot account was revoked already, by revoke-all or on
+	// expiry: revoking it again would restart its delayed deletion
+	if record == nil && accountID > 0 {
+		return nil, nil
+	}
+
+	ownRecord := recordOfLease(record, accountID)
+	if ownRecord && accountID == 0 {
+		accountID = record.ID
+	}
+
+	// Leases created before the revocation modes were added delete their robot account.
+	mode, _ := req.Secret.InternalData["revocation_mode"].(string)
+	deleteAfter, _ := internalDataInt64(req.Secret.InternalData, "revocation_delete_after")
+
+	// when Harbor is unavailable, the deletion is queued and retried by the
+	// periodic function, rather than by Vault until it gives up on the lease
+	_, err = b.revokeRobotAccount(ctx, req.Storage, &pendingRevocation{
+		ID:          accountID,
+		Name:        account,
+		Connection:  connection,
+		Mode:        mode,
+		LeaseID:     req.Secret.LeaseID,
+		DeleteAfter: time.Duration(deleteAfter) * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !ownRecord {
+		return nil, nil
+	}
+
+	if err := deleteRobotRecord(ctx, req.Storage, account); err != nil {
+		return nil, fmt.Errorf("error deleting robot account record: %w", err)
 	}
 
 	return nil, nil
 }
 
-// deleteToken calls the Harbor client to delete the robot account
-func deleteRobotAccount(ctx context.Context, c *harborClient, robotAccountName string) error {
-	err := c.RESTClient.DeleteRobotAccountByName(ctx, robotAccountName)
-	if err != nil {
+// recordOfLease tells whether a record is the one of the robot account of a
+// lease. The robot account names need not be unique across connections and
+// projects, the record of a name may be the one of another lease. The leases
+// created before the robot account ID was recorded own the record of their name.
+func recordOfLease(record *harborRobotRecord, accountID int64) bool {
+	return record != nil && (accountID == 0 || record.ID == accountID)
+}
+
+// deleteToken calls the Harbor client to delete the robot account,
+// by its ID when it is known, or by its name
+func deleteRobotAccount(ctx context.Context, c *harborClient, robotAccountID int64, robotAccountName string) error {
+	if err := c.capabilities.checkRobotAccounts(); err != nil {
+		return err
+	}
+
+	if robotAccountID == 0 {
+		robot, err := c.getRobotByName(ctx, robotAccountName)
+		if err != nil {
+			return err
+		}
+
+		// already deleted, by the expiry enforcement or from Harbor
+		if robot == nil {
+			return nil
+		}
+
+		robotAccountID = robot.ID
+	}
+
+	err := c.deleteRobot(ctx, robotAccountID)
+	if err != nil && !isHarborNotFound(err) {
 		return err
 	}
 
 	return nil
 }
 
+// internalDataInt64 reads an integer from the internal data of a secret.
+// Integers are decoded as json.Number or float64 once the lease was stored.
+func internalDataInt64(data map[string]interface{}, key string) (int64, bool) {
+	switch v := data[key].(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
 // robotAccountRenew
 func (b *harborBackend) robotAccountRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	roleRaw, ok := req.Secret.InternalData["role"]
@@ -88,6 +174,15 @@ func (b *harborBackend) robotAccountRenew(ctx context.Context, req *logical.Requ
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
+	revoked, err := robotRevokedOutsideLease(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("the robot account of the lease was revoked, it can't be renewed")
+	}
+
 	resp := &logical.Response{Secret: req.Secret}
 
 	if roleEntry.TTL > 0 {
@@ -97,5 +192,82 @@ func (b *harborBackend) robotAccountRenew(ctx context.Context, req *logical.Requ
 		resp.Secret.MaxTTL = roleEntry.MaxTTL
 	}
 
+	ttl := resp.Secret.TTL
+	if ttl <= 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+
+	if err := b.extendRobotAccount(ctx, req, time.Now().Add(ttl)); err != nil {
+		return nil, fmt.Errorf("error extending robot account expiry: %w", err)
+	}
+
 	return resp, nil
 }
+
+// robotRevokedOutsideLease tells whether the robot account of a lease was
+// revoked while the lease is live, by revoke-all or on expiry: the leases
+// carrying the robot account ID were recorded, and their record is gone.
+func robotRevokedOutsideLease(ctx context.Context, req *logical.Request) (bool, error) {
+	accountID, _ := internalDataInt64(req.Secret.InternalData, "robot_account_id")
+	if accountID == 0 {
+		return false, nil
+	}
+
+	accountName, _ := req.Secret.InternalData["robot_account_name"].(string)
+
+	record, err := getRobotRecord(ctx, req.Storage, accountName)
+	if err != nil {
+		return false, fmt.Errorf("error reading robot account record: %w", err)
+	}
+
+	return record == nil, nil
+}
+
+// extendRobotAccount updates the duration of the robot account of a lease
+// for it to expire with the renewed lease, on the same day at the earliest.
+func (b *harborBackend) extendRobotAccount(ctx context.Context, req *logical.Request, leaseExpiry time.Time) error {
+	connection, _ := req.Secret.InternalData["connection"].(string)
+	accountName, _ := req.Secret.InternalData["robot_account_name"].(string)
+	accountID, _ := internalDataInt64(req.Secret.InternalData, "robot_account_id")
+
+	client, err := b.getClient(ctx, req.Storage, connection)
+	if err != nil {
+		return err
+	}
+
+	if err := client.capabilities.checkRobotAccounts(); err != nil {
+		return err
+	}
+
+	robot, err := findRobot(ctx, client, accountID, accountName)
+	if err != nil {
+		return err
+	}
+
+	if robot == nil {
+		return fmt.Errorf("robot account %s not found in Harbor", robotDisplayName(accountID, accountName))
+	}
+
+	if robot.CreationTime.IsZero() {
+		return fmt.Errorf("harbor returned no creation time for robot account %s", robotDisplayName(accountID, accountName))
+	}
+
+	robot.Duration = robotDurationDays(leaseExpiry.Sub(robot.CreationTime))
+
+	if err := client.updateRobot(ctx, robot); err != nil {
+		return err
+	}
+
+	record, err := getRobotRecord(ctx, req.Storage, accountName)
+	if err != nil {
+		return err
+	}
+
+	if !recordOfLease(record, accountID) {
+		return nil
+	}
+
+	record.ExpiresAt = leaseExpiry
+
+	return putRobotRecord(ctx, req.Storage, accountName, record)
+}
@@ -0,0 +1,145 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRobotAccountRenew uses a fake Harbor API to check that
+// renewing a lease extends the expiry of its robot account.
+func TestRobotAccountRenew(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.RobotCreated = time.Now().Add(-36 * time.Hour)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	_, err = testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions": testPermissions,
+		"ttl":         "24h",
+		"max_ttl":     "72h",
+	})
+	require.NoError(t, err)
+
+	renew := func(internalData map[string]interface{}) (*logical.Response, error) {
+		secret := &logical.Secret{InternalData: internalData}
+		secret.IssueTime = time.Now()
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Secret:    secret,
+			Storage:   s,
+		})
+	}
+
+	t.Run("by ID", func(t *testing.T) {
+		require.NoError(t, putRobotRecord(context.Background(), s, robotName, &harborRobotRecord{
+			ID: 7, Name: robotName, ExpiresAt: time.Now(),
+		}))
+
+		resp, err := renew(map[string]interface{}{
+			"secret_type":        harborRobotAccountType,
+			"role":               roleName,
+			"robot_account_id":   int64(7),
+			"robot_account_name": robotName,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 24*time.Hour, resp.Secret.TTL)
+
+		// created 36h ago and renewed for 24h: the robot must live 60h, so 3 days
+		require.Equal(t, float64(3), harborServer.RobotDuration)
+	})
+
+	t.Run("robot not found", func(t *testing.T) {
+		_, err := renew(map[string]interface{}{
+			"secret_type":        harborRobotAccountType,
+			"role":               roleName,
+			"robot_account_id":   int64(8),
+			"robot_account_name": "robot$missing",
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("record of another lease", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		require.NoError(t, putRobotRecord(context.Background(), s, robotName, &harborRobotRecord{
+			ID: 9, Name: robotName, ExpiresAt: expiresAt,
+		}))
+
+		_, err := renew(map[string]interface{}{
+			"secret_type":        harborRobotAccountType,
+			"role":               roleName,
+			"robot_account_id":   int64(7),
+			"robot_account_name": robotName,
+		})
+		require.NoError(t, err)
+
+		record, err := getRobotRecord(context.Background(), s, robotName)
+		require.NoError(t, err)
+		require.Equal(t, int64(9), record.ID)
+		require.True(t, expiresAt.Equal(record.ExpiresAt))
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		require.NoError(t, deleteRobotRecord(context.Background(), s, robotName))
+
+		_, err := renew(map[string]interface{}{
+			"secret_type":        harborRobotAccountType,
+			"role":               roleName,
+			"robot_account_id":   int64(7),
+			"robot_account_name": robotName,
+		})
+		require.ErrorContains(t, err, "was revoked")
+	})
+}
+
+// TestRobotAccountRevoke uses a fake Harbor API to check that revoking a lease
+// deletes its own robot account, even when another lease of the same name
+// holds the record.
+func TestRobotAccountRevoke(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(70), "name": "robot$team-a+vault.shared"},
+		{"id": int64(71), "name": "robot$team-b+vault.shared"},
+	}
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.NoError(t, putRobotRecord(ctx, s, "vault.shared", &harborRobotRecord{
+		ID: 71, Name: "robot$team-b+vault.shared", ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret: &logical.Secret{InternalData: map[string]interface{}{
+			"secret_type":        harborRobotAccountType,
+			"robot_account_id":   int64(70),
+			"robot_account_name": "vault.shared",
+		}},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{70}, harborServer.DeletedRobots)
+
+	record, err := getRobotRecord(ctx, s, "vault.shared")
+	require.NoError(t, err)
+	require.Equal(t, int64(71), record.ID)
+}
@@ -0,0 +1,107 @@
+package harbor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+const (
+	// defaultNameTemplate renders the robot account names
+	// the backend used before name templates were configurable.
+	defaultNameTemplate = `vault.{{ .RoleName }}.{{ if .DisplayName }}{{ .DisplayName }}.{{ end }}{{ unix_time_nano }}`
+
+	// maxRobotNameLength is the longest robot account name Harbor stores
+	maxRobotNameLength = 255
+)
+
+var (
+	// robotNameRegex matches the robot account names Harbor accepts
+	robotNameRegex = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+	displayNameInvalidChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+	displayNameSeparators   = regexp.MustCompile(`[._-]{2,}`)
+)
+
+// robotNameData is the data available to the robot account name templates.
+type robotNameData struct {
+	RoleName    string
+	DisplayName string
+	EntityID    string
+}
+
+// newNameTemplate parses a robot account name template. Besides
+// the functions of the Vault username templates, it provides
+// unix_time_nano, used by the default template.
+func newNameTemplate(rawTemplate string) (template.StringTemplate, error) {
+	return template.NewTemplate(
+		template.Template(rawTemplate),
+		template.Function("unix_time_nano", func() string {
+			return strconv.FormatInt(time.Now().UnixNano(), 10)
+		}),
+	)
+}
+
+// renderRobotName renders a robot account name from the template,
+// and checks Harbor accepts it before any call to Harbor.
+func renderRobotName(rawTemplate string, data robotNameData) (string, error) {
+	if rawTemplate == "" {
+		rawTemplate = defaultNameTemplate
+	}
+
+	tmpl, err := newNameTemplate(rawTemplate)
+	if err != nil {
+		return "", fmt.Errorf("error parsing name_template: %w", err)
+	}
+
+	name, err := tmpl.Generate(data)
+	if err != nil {
+		return "", fmt.Errorf("error rendering name_template: %w", err)
+	}
+
+	if err := validateRobotName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// validateRobotName checks the name of a robot account
+// against the characters and length Harbor allows.
+func validateRobotName(name string) error {
+	if len(name) > maxRobotNameLength {
+		return fmt.Errorf("robot account name %q is longer than %d characters", name, maxRobotNameLength)
+	}
+
+	if !robotNameRegex.MatchString(name) {
+		return fmt.Errorf("robot account name %q is invalid, Harbor only allows lowercase letters and digits, "+
+			"separated by single '.', '_' or '-'", name)
+	}
+
+	return nil
+}
+
+// sanitizeDisplayName turns a Vault display name into
+// a valid part of a robot account name.
+func sanitizeDisplayName(displayName string) string {
+	dn := displayNameInvalidChars.ReplaceAllString(strings.ToLower(displayName), "-")
+	dn = displayNameSeparators.ReplaceAllString(dn, "-")
+
+	return strings.Trim(dn, "._-")
+}
+
+// checkNameTemplate parses a robot account name template and renders it
+// with sample data, so that broken templates are rejected when written.
+func checkNameTemplate(rawTemplate string) error {
+	_, err := renderRobotName(rawTemplate, robotNameData{
+		RoleName:    "role",
+		DisplayName: "token",
+		EntityID:    "00000000-0000-0000-0000-000000000000",
+	})
+
+	return err
+}
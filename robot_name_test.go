@@ -0,0 +1,98 @@
+package harbor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderRobotName(t *testing.T) {
+	data := robotNameData{
+		RoleName:    "test-role",
+		DisplayName: sanitizeDisplayName("oidc-John.Doe@example.com"),
+		EntityID:    "5a4f3c1e-6b2d-4f8a-9c0e-1d2b3c4d5e6f",
+	}
+
+	t.Run("default template", func(t *testing.T) {
+		name, err := renderRobotName("", data)
+		require.NoError(t, err)
+		require.Regexp(t, `^vault\.test-role\.oidc-john\.doe-example\.com\.[0-9]+$`, name)
+	})
+
+	t.Run("default template without display name", func(t *testing.T) {
+		name, err := renderRobotName("", robotNameData{RoleName: "test-role"})
+		require.NoError(t, err)
+		require.Regexp(t, `^vault\.test-role\.[0-9]+$`, name)
+	})
+
+	t.Run("custom template", func(t *testing.T) {
+		name, err := renderRobotName(
+			`{{ .RoleName }}-{{ .DisplayName | truncate_sha256 12 }}-{{ random 8 | lowercase }}`, data)
+		require.NoError(t, err)
+		require.Regexp(t, `^test-role-[a-z0-9.-]{12}-[a-z0-9]{8}$`, name)
+	})
+
+	t.Run("entity ID", func(t *testing.T) {
+		name, err := renderRobotName(`vault.{{ .EntityID }}`, data)
+		require.NoError(t, err)
+		require.Equal(t, "vault."+data.EntityID, name)
+	})
+
+	t.Run("invalid characters", func(t *testing.T) {
+		_, err := renderRobotName(`{{ .RoleName | uppercase }}`, data)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "lowercase letters and digits")
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		_, err := renderRobotName(strings.Repeat("a", maxRobotNameLength+1), data)
+		require.Error(t, err)
+	})
+
+	t.Run("broken template", func(t *testing.T) {
+		require.Error(t, checkNameTemplate(`{{ .RoleName `))
+		require.Error(t, checkNameTemplate(`{{ .Unknown }}`))
+		require.NoError(t, checkNameTemplate(`vault.{{ .RoleName }}.{{ unix_time }}`))
+	})
+}
+
+func TestSanitizeDisplayName(t *testing.T) {
+	require.Equal(t, "token", sanitizeDisplayName("token"))
+	require.Equal(t, "oidc-jane-doe", sanitizeDisplayName("oidc-Jane Doe"))
+	require.Equal(t, "ldap-jane", sanitizeDisplayName("--ldap--jane__"))
+	require.Equal(t, "", sanitizeDisplayName(""))
+}
+
+// failingStorage fails to read the storage entries
+type failingStorage struct {
+	logical.Storage
+}
+
+func (failingStorage) Get(context.Context, string) (*logical.StorageEntry, error) {
+	return nil, errors.New("storage unavailable")
+}
+
+// TestRobotAccountName checks that an invalid name template is reported as
+// a user error, while a storage failure is returned as an error.
+func TestRobotAccountName(t *testing.T) {
+	b, s := getTestBackend(t)
+	req := &logical.Request{Storage: s, DisplayName: "token"}
+
+	name, resp, err := b.robotAccountName(context.Background(), req, "test-role", &harborRoleEntry{})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.True(t, strings.HasPrefix(name, "vault.test-role.token."))
+
+	_, resp, err = b.robotAccountName(context.Background(), req, "test-role", &harborRoleEntry{NameTemplate: "{{.RoleName}}!"})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	req.Storage = failingStorage{s}
+	_, resp, err = b.robotAccountName(context.Background(), req, "test-role", &harborRoleEntry{})
+	require.Error(t, err)
+	require.Nil(t, resp)
+}
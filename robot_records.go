@@ -0,0 +1,145 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// robotStoragePrefix is the storage prefix of the robot account records,
+// keyed by the name the backend gave to the robot account.
+const robotStoragePrefix = "robot/"
+
+// harborRobotRecord records a robot account created by the backend, so
+// that it is deleted when it expires even if its lease isn't revoked.
+// Harbor counts the robot account durations in days, while leases can
+// be much shorter. The records are the inventory of the live robot accounts.
+type harborRobotRecord struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Connection string    `json:"connection"`
+	Role       string    `json:"role"`
+	EntityID   string    `json:"entity_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// Projects and Actions are the scope the credentials were narrowed to,
+	// kept when the permissions of the role are propagated
+	Projects []string `json:"projects,omitempty"`
+	Actions  []string `json:"actions,omitempty"`
+	// ScopeRecorded tells the records of a full role apart from the
+	// records written before the scope was, which lack it
+	ScopeRecorded bool `json:"scope_recorded,omitempty"`
+
+	// RevocationMode and RevocationDeleteAfter are the revocation settings
+	// of the role, applied when the robot account expires. The records
+	// written before them delete their robot account.
+	RevocationMode        string        `json:"revocation_mode,omitempty"`
+	RevocationDeleteAfter time.Duration `json:"revocation_delete_after,omitempty"`
+}
+
+// toResponseData returns response data for a robot account record,
+// the records written before the inventory lack the role, entity ID
+// and creation time.
+func (r *harborRobotRecord) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"robot_account_id":   r.ID,
+		"robot_account_name": r.Name,
+		"connection":         r.Connection,
+		"role":               r.Role,
+		"entity_id":          r.EntityID,
+		"created_at":         "",
+		"expires_at":         r.ExpiresAt.Format(time.RFC3339),
+	}
+	if !r.CreatedAt.IsZero() {
+		data["created_at"] = r.CreatedAt.Format(time.RFC3339)
+	}
+
+	return data
+}
+
+// putRobotRecord stores the record of a robot account
+func putRobotRecord(ctx context.Context, s logical.Storage, name string, record *harborRobotRecord) error {
+	entry, err := logical.StorageEntryJSON(robotStoragePrefix+name, record)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for robot account record")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getRobotRecord gets the record of a robot account, it returns nil
+// for the robot accounts created before the records were kept.
+func getRobotRecord(ctx context.Context, s logical.Storage, name string) (*harborRobotRecord, error) {
+	entry, err := s.Get(ctx, robotStoragePrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var record harborRobotRecord
+
+	if err := entry.DecodeJSON(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// deleteRobotRecord deletes the record of a robot account
+func deleteRobotRecord(ctx context.Context, s logical.Storage, name string) error {
+	return s.Delete(ctx, robotStoragePrefix+name)
+}
+
+// enforceRobotExpiry revokes in Harbor the robot accounts whose recorded
+// expiry has passed, whatever their lease says, as their role revokes them.
+func (b *harborBackend) enforceRobotExpiry(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, robotStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, name := range names {
+		record, err := getRobotRecord(ctx, s, name)
+		if err != nil {
+			b.Logger().Error("error reading robot account record", "robot_account", name, "error", err)
+			continue
+		}
+
+		if record == nil || now.Before(record.ExpiresAt) {
+			continue
+		}
+
+		// a failed revocation is queued, the record can go
+		_, err = b.revokeRobotAccount(ctx, s, &pendingRevocation{
+			ID:          record.ID,
+			Name:        name,
+			Connection:  record.Connection,
+			Mode:        record.RevocationMode,
+			DeleteAfter: record.RevocationDeleteAfter,
+		})
+		if err != nil {
+			b.Logger().Error("error revoking expired robot account", "robot_account", name, "error", err)
+			continue
+		}
+
+		if err := deleteRobotRecord(ctx, s, name); err != nil {
+			b.Logger().Error("error deleting robot account record", "robot_account", name, "error", err)
+			continue
+		}
+
+		b.Logger().Info("revoked expired robot account", "robot_account", name, "expired_at", record.ExpiresAt)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEnforceRobotExpiry uses a fake Harbor API to check that the
+// robot accounts are deleted once their recorded expiry has passed.
+func TestEnforceRobotExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.NoError(t, putRobotRecord(ctx, s, "vault", &harborRobotRecord{
+		ID: 7, Name: robotName, ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	t.Run("not expired yet", func(t *testing.T) {
+		require.NoError(t, b.enforceRobotExpiry(ctx, s))
+		require.False(t, harborServer.RobotDeleted)
+
+		record, err := getRobotRecord(ctx, s, "vault")
+		require.NoError(t, err)
+		require.NotNil(t, record)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		require.NoError(t, putRobotRecord(ctx, s, "vault", &harborRobotRecord{
+			ID: 7, Name: robotName, ExpiresAt: time.Now().Add(-time.Minute),
+		}))
+		// already deleted from Harbor
+		require.NoError(t, putRobotRecord(ctx, s, "vault.test.2", &harborRobotRecord{
+			ID: 8, Name: "robot$vault.test.2", ExpiresAt: time.Now().Add(-time.Minute),
+		}))
+
+		require.NoError(t, b.enforceRobotExpiry(ctx, s))
+		require.True(t, harborServer.RobotDeleted)
+
+		names, err := s.List(ctx, robotStoragePrefix)
+		require.NoError(t, err)
+		require.Empty(t, names)
+	})
+
+	t.Run("revoked after deletion", func(t *testing.T) {
+		// the lease of a deleted robot account is still revoked
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret: &logical.Secret{InternalData: map[string]interface{}{
+				"secret_type":        harborRobotAccountType,
+				"robot_account_name": "vault",
+			}},
+			Storage: s,
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,108 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+// walRobotAccountKind is the kind of the write-ahead log entries of the
+// robot accounts being created.
+const walRobotAccountKind = "robotAccount"
+
+// walRobotAccount is written before a robot account is created in Harbor, and
+// deleted once it is recorded. When the creation doesn't complete, the robot
+// account may exist in Harbor without a lease: the rollback deletes it.
+type walRobotAccount struct {
+	Connection string `json:"connection" mapstructure:"connection"`
+	RobotName  string `json:"robot_name" mapstructure:"robot_name"`
+
+	// CreatedAt is the time the creation started, the entries
+	// written before it was recorded have a zero time
+	CreatedAt time.Time `json:"created_at" mapstructure:"created_at"`
+}
+
+// walRollback deletes the robot account of a write-ahead log entry,
+// if it was created in Harbor. The name templates of the roles need not
+// be unique, so the robot accounts recorded for another lease of the same
+// name are left alone, with their record.
+func (b *harborBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	if kind != walRobotAccountKind {
+		return fmt.Errorf("unknown write-ahead log entry kind %q", kind)
+	}
+
+	var entry walRobotAccount
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+		Result:     &entry,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.Decode(data); err != nil {
+		return err
+	}
+
+	record, err := getRobotRecord(ctx, req.Storage, entry.RobotName)
+	if err != nil {
+		return err
+	}
+
+	// a record older than the entry is the one of a lease issued before,
+	// the robot account of the entry can't be told apart from its own
+	if record != nil && record.CreatedAt.Before(entry.CreatedAt) {
+		b.Logger().Warn("skipped the rollback of a robot account whose name is used by a lease",
+			"robot_account", entry.RobotName)
+		return nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	if err := client.capabilities.checkRobotAccounts(); err != nil {
+		return err
+	}
+
+	robots, err := client.listRobots(ctx, entry.RobotName)
+	if err != nil {
+		return fmt.Errorf("error listing Harbor robot accounts: %w", err)
+	}
+
+	for _, robot := range robots {
+		// the name of the robot account is prefixed in Harbor,
+		// and with the project of the project robot accounts
+		if robotShortName(robot.Name) != entry.RobotName {
+			continue
+		}
+
+		if record != nil && record.ID != robot.ID {
+			b.Logger().Warn("skipped the rollback of a robot account recorded for another lease",
+				"robot_account", robot.Name)
+			continue
+		}
+
+		if err := client.deleteRobot(ctx, robot.ID); err != nil && !isHarborNotFound(err) {
+			return fmt.Errorf("error deleting Harbor robot account: %w", err)
+		}
+
+		b.Logger().Info("rolled back robot account", "robot_account", robot.Name)
+	}
+
+	if record == nil {
+		return nil
+	}
+
+	// the creation was recorded, but not committed
+	if slices.ContainsFunc(robots, func(robot *harborRobot) bool { return robot.ID == record.ID }) {
+		return deleteRobotRecord(ctx, req.Storage, entry.RobotName)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWALRollback uses a fake Harbor API to check that the rollback deletes
+// the robot accounts whose creation didn't complete, and only them.
+func TestWALRollback(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(20), "name": "robot$vault.role.1"},
+		{"id": int64(21), "name": "robot$library+vault.role.2"},
+		{"id": int64(22), "name": "robot$vault.role.10"},
+		// a name shared by the live leases of a role without a unique name template
+		{"id": int64(23), "name": "robot$vault.shared"},
+		{"id": int64(24), "name": "robot$vault.reused"},
+	}
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	for _, name := range []string{"vault.role.1", "vault.role.2", "vault.role.3", "vault.shared", "vault.reused"} {
+		_, err := framework.PutWAL(context.Background(), s, walRobotAccountKind, &walRobotAccount{RobotName: name, CreatedAt: now})
+		require.NoError(t, err)
+	}
+
+	err = putRobotRecord(context.Background(), s, "vault.role.1", &harborRobotRecord{
+		ID:        20,
+		CreatedAt: now.Add(time.Second),
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// recorded for another robot account
+	err = putRobotRecord(context.Background(), s, "vault.shared", &harborRobotRecord{
+		ID:        25,
+		CreatedAt: now.Add(time.Second),
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// recorded before the creation started
+	err = putRobotRecord(context.Background(), s, "vault.reused", &harborRobotRecord{
+		ID:        24,
+		CreatedAt: now.Add(-time.Minute),
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   s,
+		Data:      map[string]interface{}{"immediate": true},
+	})
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, []int64{20, 21}, harborServer.DeletedRobots)
+
+	record, err := getRobotRecord(context.Background(), s, "vault.role.1")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	for _, name := range []string{"vault.shared", "vault.reused"} {
+		record, err := getRobotRecord(context.Background(), s, name)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+	}
+
+	wals, err := framework.ListWAL(context.Background(), s)
+	require.NoError(t, err)
+	require.Empty(t, wals)
+}
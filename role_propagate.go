@@ -0,0 +1,176 @@
+package harbor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	propagationUpdated = "updated"
+	propagationRevoked = "revoked"
+	propagationSkipped = "skipped"
+	propagationFailed  = "failed"
+)
+
+// errScopeNotRecorded is returned for the robot accounts recorded before their
+// scope was: they may have been narrowed, and would get the whole role.
+var errScopeNotRecorded = errors.New("the scope of the robot account wasn't recorded")
+
+// propagateRole updates the permissions of the live robot accounts of a role
+// to the current permissions of the role, within the scope each robot account
+// was narrowed to. The robot accounts whose scope the role doesn't grant
+// anymore are revoked. It returns the outcome for each robot account, and how
+// many were skipped or failed.
+func (b *harborBackend) propagateRole(
+	ctx context.Context,
+	s logical.Storage,
+	roleName string,
+	role *harborRoleEntry,
+) ([]map[string]interface{}, int, error) {
+	names, err := s.List(ctx, robotStoragePrefix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := []map[string]interface{}{}
+	failed := 0
+
+	for _, name := range names {
+		record, err := getRobotRecord(ctx, s, name)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if record == nil || record.Role != roleName {
+			continue
+		}
+
+		result := map[string]interface{}{
+			"robot_account_id":   record.ID,
+			"robot_account_name": record.Name,
+			"success":            true,
+		}
+
+		outcome, err := b.propagateToRobot(ctx, s, role, name, record)
+		if err != nil {
+			b.Logger().Error("error propagating role permissions", "role", roleName, "robot_account", record.Name, "error", err)
+			result["success"] = false
+			result["error"] = err.Error()
+			failed++
+		}
+		result["outcome"] = outcome
+
+		results = append(results, result)
+	}
+
+	return results, failed, nil
+}
+
+// propagateToRobot updates the permissions of a robot account to the ones
+// the role would grant it now, within the part of its scope the role still
+// grants. It revokes the robot account when nothing of its scope is left.
+func (b *harborBackend) propagateToRobot(
+	ctx context.Context,
+	s logical.Storage,
+	role *harborRoleEntry,
+	name string,
+	record *harborRobotRecord,
+) (string, error) {
+	if !record.ScopeRecorded {
+		return propagationSkipped, errScopeNotRecorded
+	}
+
+	permissions, err := b.resolvePermissionTemplates(record.EntityID, role.Permissions)
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	robotRole := *role
+	robotRole.Permissions = permissions
+
+	client, err := b.getClient(ctx, s, record.Connection)
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	if err := client.capabilities.checkRobotAccounts(); err != nil {
+		return propagationFailed, err
+	}
+
+	permissions, err = expandNamespaces(ctx, client, robotPermissions(&robotRole), robotRole.coverAllProjects())
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	scope, ok := intersectScope(permissions, credsScope{
+		Projects: record.Projects,
+		Actions:  record.Actions,
+	})
+	if !ok {
+		return b.revokePropagatedRobot(ctx, s, name, record)
+	}
+
+	permissions, err = narrowPermissions(permissions, scope)
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	if err := client.capabilities.checkPermissions(permissions); err != nil {
+		return propagationFailed, err
+	}
+
+	robot, err := client.getRobot(ctx, record.ID)
+	if isHarborNotFound(err) {
+		return propagationFailed, fmt.Errorf("robot account %s not found in Harbor", robotDisplayName(record.ID, record.Name))
+	}
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	// Harbor doesn't change the level of a robot account
+	if robot.Level != "" && robot.Level != robotRole.robotLevel() {
+		return propagationFailed, fmt.Errorf("robot account is of level %s, the role now creates robot accounts of level %s", robot.Level, robotRole.robotLevel())
+	}
+
+	robot.Permissions = permissions
+
+	if err := client.updateRobot(ctx, robot); err != nil {
+		return propagationFailed, err
+	}
+
+	// later propagations start from the narrowed scope
+	record.Projects = scope.Projects
+	record.Actions = scope.Actions
+
+	if err := putRobotRecord(ctx, s, name, record); err != nil {
+		return propagationFailed, err
+	}
+
+	return propagationUpdated, nil
+}
+
+// revokePropagatedRobot revokes a robot account the role doesn't grant
+// anything of its scope anymore, as its role revokes it. Its lease can't
+// be renewed anymore.
+func (b *harborBackend) revokePropagatedRobot(ctx context.Context, s logical.Storage, name string, record *harborRobotRecord) (string, error) {
+	// a failed revocation is queued
+	_, err := b.revokeRobotAccount(ctx, s, &pendingRevocation{
+		ID:          record.ID,
+		Name:        name,
+		Connection:  record.Connection,
+		Mode:        record.RevocationMode,
+		DeleteAfter: record.RevocationDeleteAfter,
+	})
+	if err != nil {
+		return propagationFailed, err
+	}
+
+	if err := deleteRobotRecord(ctx, s, name); err != nil {
+		return propagationFailed, fmt.Errorf("error deleting robot account record: %w", err)
+	}
+
+	return propagationRevoked, nil
+}
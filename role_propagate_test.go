@@ -0,0 +1,120 @@
+package harbor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPropagateRole uses a fake Harbor API to check that the new permissions
+// of a role are applied to its live robot accounts.
+func TestPropagateRole(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	harborServer := newFakeHarbor(t)
+	harborServer.Robots = []map[string]interface{}{
+		{"id": int64(60), "name": "robot$vault.test-role.1", "level": "system"},
+		{"id": int64(61), "name": "robot$public+vault.test-role.2", "level": "project"},
+		{"id": int64(62), "name": "robot$vault.test-role.3", "level": "system"},
+		{"id": int64(65), "name": "robot$vault.test-role.5", "level": "system"},
+		{"id": int64(66), "name": "robot$vault.test-role.6", "level": "system"},
+	}
+
+	err := testConfigCreate(b, s, map[string]interface{}{
+		"username": username,
+		"password": password,
+		"url":      harborServer.URL,
+	})
+	require.NoError(t, err)
+
+	_, err = testTokenRoleCreate(t, b, s, roleName, map[string]interface{}{
+		"permissions": testPermissions,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for name, record := range map[string]*harborRobotRecord{
+		"vault.test-role.1": {ID: 60, Name: "robot$vault.test-role.1", Role: roleName},
+		"vault.test-role.2": {ID: 61, Name: "robot$public+vault.test-role.2", Role: roleName},
+		"vault.test-role.3": {ID: 62, Name: "robot$vault.test-role.3", Role: roleName, Actions: []string{"push"}},
+		"vault.test-role.4": {ID: 63, Name: "robot$vault.test-role.4", Role: roleName},
+		"vault.test-role.6": {ID: 66, Name: "robot$vault.test-role.6", Role: roleName, Actions: []string{"push", "pull"}},
+		"vault.other.1":     {ID: 64, Name: "robot$vault.other.1", Role: "other"},
+	} {
+		record.ScopeRecorded = true
+		record.ExpiresAt = time.Now().Add(time.Hour)
+		require.NoError(t, putRobotRecord(ctx, s, name, record))
+	}
+
+	// recorded before the scope was
+	require.NoError(t, putRobotRecord(ctx, s, "vault.test-role.5", &harborRobotRecord{
+		ID: 65, Name: "robot$vault.test-role.5", Role: roleName, ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	// a write is routed as an update once the existence check finds the role
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/" + roleName,
+		Storage:   s,
+		Data: map[string]interface{}{
+			"permissions": `[{"kind":"project","namespace":"public","access":[{"resource":"repository","action":"pull"}]}]`,
+			"propagate":   true,
+		},
+	}
+	checkFound, exists, err := b.HandleExistenceCheck(ctx, req)
+	require.NoError(t, err)
+	require.True(t, checkFound)
+	require.True(t, exists)
+	req.Operation = logical.UpdateOperation
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	require.Len(t, resp.Warnings, 1)
+
+	results := map[int64]map[string]interface{}{}
+	for _, result := range resp.Data["propagation"].([]map[string]interface{}) {
+		results[result["robot_account_id"].(int64)] = result
+	}
+	require.Len(t, results, 6)
+
+	require.Equal(t, true, results[60]["success"])
+	require.Equal(t, propagationUpdated, results[60]["outcome"])
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"kind":      "project",
+		"namespace": "public",
+		"access":    []interface{}{map[string]interface{}{"resource": "repository", "action": "pull"}},
+	}}, harborServer.Robots[0]["permissions"])
+
+	// the level of a robot account can't change
+	require.Equal(t, false, results[61]["success"])
+	require.Contains(t, results[61]["error"], "level project")
+
+	// the robot account was narrowed to an action the role doesn't grant anymore
+	require.Equal(t, true, results[62]["success"])
+	require.Equal(t, propagationRevoked, results[62]["outcome"])
+	require.Equal(t, []int64{62}, harborServer.DeletedRobots)
+
+	record, err := getRobotRecord(ctx, s, "vault.test-role.3")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	require.Equal(t, false, results[63]["success"])
+	require.Equal(t, propagationFailed, results[63]["outcome"])
+	require.Contains(t, results[63]["error"], "not found")
+
+	require.Equal(t, false, results[65]["success"])
+	require.Equal(t, propagationSkipped, results[65]["outcome"])
+	require.Nil(t, harborServer.Robots[3]["permissions"])
+
+	// the robot account keeps the actions the role still grants
+	require.Equal(t, true, results[66]["success"])
+	require.Equal(t, propagationUpdated, results[66]["outcome"])
+
+	record, err = getRobotRecord(ctx, s, "vault.test-role.6")
+	require.NoError(t, err)
+	require.Equal(t, []string{"pull"}, record.Actions)
+}